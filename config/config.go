@@ -2,32 +2,131 @@
 package config
 
 import (
+	"fmt"
 	"github.com/BurntSushi/toml"
+	"github.com/nevsnode/gordon/cron"
 	"github.com/nevsnode/gordon/utils"
 	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // DefaultConfig describes the default path to the configuration file.
 const DefaultConfig = "./gordon.config.toml"
 
+// Available values for the fetch-mode.
+const (
+	FetchModePoll   = "poll"   // check for new tasks in the configured interval
+	FetchModeNotify = "notify" // wait for keyspace notifications, and poll just as a fallback
+)
+
+// Available values for the failure-mode of tasks.
+const (
+	FailureModeExitCode = "exit_code" // tasks fail when exiting with a code other than 0
+	FailureModeStdout   = "stdout"    // tasks fail on an exit code other than 0, or when writing to stdout
+	FailureModeStderr   = "stderr"    // tasks fail on an exit code other than 0, or when writing to stderr
+)
+
+// Available modes for draining the lists of tasks with multiple priorities.
+const (
+	PriorityModeStrict   = "strict"   // always fetch tasks with the highest available priority first
+	PriorityModeWeighted = "weighted" // fetch tasks of all priorities, according to their weights
+)
+
+// Available values for the handling of expired tasks.
+const (
+	ExpiredModeDiscard = "discard" // expired tasks are dropped
+	ExpiredModeList    = "list"    // expired tasks are moved to the expired-list of their type
+)
+
+// Available rules for deriving the unique key of tasks from their arguments.
+const (
+	UniqueArgs    = "args"    // tasks with the same arguments are duplicates
+	UniquePayload = "payload" // tasks with the same arguments and environment variables are duplicates
+)
+
+// DefaultPriority is the default name of the priority whose list is named without suffix.
+const DefaultPriority = "normal"
+
+// DefaultRetryExitDelay is the delay in seconds before retrying a task on request of its exit-code,
+// when no delay greater than 0 is configured in retry_delays.
+const DefaultRetryExitDelay = 60
+
+// reservedPriorities contains names which are already used as suffixes of other lists.
+var reservedPriorities = map[string]bool{
+	"failed":     true,
+	"scheduled":  true,
+	"processing": true,
+	"unique":     true,
+	"ratelimit":  true,
+	"semaphore":  true,
+	"expired":    true,
+}
+
+// reservedTaskTypes contains names which are already used by global keys below the queue key.
+var reservedTaskTypes = map[string]bool{
+	"cancel":      true,
+	"concurrency": true,
+	"cron":        true,
+	"instance":    true,
+	"instances":   true,
+	"result":      true,
+	"task":        true,
+	"waiting":     true,
+	"workflow":    true,
+	"workflows":   true,
+}
+
+// Available policies for cron ticks that were missed, while no instance was running.
+const (
+	CronMissedSkip = "skip" // ignore missed ticks
+	CronMissedOnce = "once" // enqueue the task once for all missed ticks
+	CronMissedAll  = "all"  // enqueue the task for every missed tick
+)
+
 // A Config stores values, necessary for the execution of Gordon.
 type Config struct {
-	RedisNetwork   string          `toml:"redis_network"`    // network type used for the connection to Redis
-	RedisAddress   string          `toml:"redis_address"`    // network address used for the connection to Redis
-	RedisQueueKey  string          `toml:"queue_key"`        // first part of the list-names used in Redis
-	ErrorScript    string          `toml:"error_script"`     // path to script/application that is executed when a task created an error
-	FailedTasksTTL int             `toml:"failed_tasks_ttl"` // ttl for the lists that store failed tasks
-	TempDir        string          `toml:"temp_dir"`         // path to a directory that is used for temporary files
-	IntervalMin    int             `toml:"interval_min"`     // minimum interval for checking for new tasks
-	IntervalMax    int             `toml:"interval_max"`     // maxiumum interval for checking for new tasks
-	IntervalFactor float64         `toml:"interval_factor"`  // multiplicator for the task-check interval
-	BackoffEnabled bool            `toml:"backoff_enabled"`  // general flag to disable/enable error-backoff
-	BackoffMin     int             `toml:"backoff_min"`      // general error-backoff start value in milliseconds
-	BackoffMax     int             `toml:"backoff_max"`      // general error-backoff maximum value in milliseconds
-	BackoffFactor  float64         `toml:"backoff_factor"`   // general error-backoff multiplicator
-	Logfile        string          // a file where all output will be written to, instead of stdout
-	Stats          StatsConfig     // options for the statistics package
-	Tasks          map[string]Task // map of available tasks that Gordon can execute
+	RedisNetwork     string          `toml:"redis_network"`      // network type used for the connection to Redis
+	RedisAddress     string          `toml:"redis_address"`      // network address used for the connection to Redis
+	RedisQueueKey    string          `toml:"queue_key"`          // first part of the list-names used in Redis
+	ErrorScript      string          `toml:"error_script"`       // path to script/application that is executed when a task created an error
+	FailedTasksTTL   int             `toml:"failed_tasks_ttl"`   // ttl for the entries in the lists that store failed tasks
+	FailedTasksMax   int             `toml:"failed_tasks_max"`   // maximum length of the lists that store failed tasks
+	TempDir          string          `toml:"temp_dir"`           // path to a directory that is used for temporary files
+	MaxWorkers       int             `toml:"max_workers"`        // budget for the costs of all tasks running at the same time in this instance
+	IntervalMin      int             `toml:"interval_min"`       // minimum interval for checking for new tasks
+	IntervalMax      int             `toml:"interval_max"`       // maxiumum interval for checking for new tasks
+	IntervalFactor   float64         `toml:"interval_factor"`    // multiplicator for the task-check interval
+	FetchMode        string          `toml:"fetch_mode"`         // the way new tasks are detected (poll or notify)
+	BackoffEnabled   bool            `toml:"backoff_enabled"`    // general flag to disable/enable error-backoff
+	BackoffMin       int             `toml:"backoff_min"`        // general error-backoff start value in milliseconds
+	BackoffMax       int             `toml:"backoff_max"`        // general error-backoff maximum value in milliseconds
+	BackoffFactor    float64         `toml:"backoff_factor"`     // general error-backoff multiplicator
+	FailureMode      string          `toml:"failure_mode"`       // general condition for considering a task failed
+	OutputLimit      int             `toml:"output_limit"`       // general maximum number of bytes captured from stdout and stderr of a task
+	Timeout          int             `toml:"timeout"`            // general time in seconds after which the execution of a task is terminated
+	KillTimeout      int             `toml:"kill_timeout"`       // general time in seconds to wait after terminating a task, before killing it
+	RetryExitCodes   []int           `toml:"retry_exit_codes"`   // general exit-codes of tasks that request to be retried
+	DiscardExitCodes []int           `toml:"discard_exit_codes"` // general exit-codes of tasks that request to be discarded
+	MaxAttempts      int             `toml:"max_attempts"`       // general number of times a task is executed, before it is considered failed
+	MaxRetries       int             `toml:"max_retries"`        // general number of times a task is retried on request of its exit-code
+	RetryDelays      []int           `toml:"retry_delays"`       // general delays in seconds before retrying a failed task, per attempt
+	WorkflowTTL      int             `toml:"workflow_ttl"`       // time in seconds the state of a workflow is stored
+	ResultTTL        int             `toml:"result_ttl"`         // general time in seconds the result of a task is stored
+	StatusTTL        int             `toml:"status_ttl"`         // general time in seconds the status of a task is stored, 0 disables the tracking
+	StallTimeout     int             `toml:"stall_timeout"`      // general time in seconds without heartbeat, after which a running task is reported as stalled
+	UniqueTTL        int             `toml:"unique_ttl"`         // general time in seconds after which the uniqueness of a task expires
+	TTL              int             `toml:"ttl"`                // general time in seconds after which tasks expire, when they were not executed yet
+	ExpiredMode      string          `toml:"expired_mode"`       // general handling of tasks that expired
+	ExpiredTasksTTL  int             `toml:"expired_tasks_ttl"`  // general ttl for the entries in the lists that store expired tasks
+	ExpiredTasksMax  int             `toml:"expired_tasks_max"`  // general maximum length of the lists that store expired tasks
+	ShutdownTimeout  int             `toml:"shutdown_timeout"`   // seconds to wait for running tasks on shutdown, before interrupting them
+	InstanceTimeout  int             `toml:"instance_timeout"`   // seconds without heartbeat, after which an instance is considered dead
+	Logfile          string          // a file where all output will be written to, instead of stdout
+	Stats            StatsConfig     // options for the statistics package
+	Tasks            map[string]Task // map of available tasks that Gordon can execute
+	Cron             []Cron          // list of tasks that Gordon enqueues on a schedule
 }
 
 // StatsConfig contains configuration options for the stats-package/service.
@@ -38,14 +137,108 @@ type StatsConfig struct {
 
 // A Task stores information that task-workers need to execute their script/application.
 type Task struct {
-	Type           string  // second part of the list-names used in Redis and used to identify tasks
-	Script         string  // path to the script/application that this task should execute
-	Workers        int     // number of concurrent go-routines available for this task
-	FailedTasksTTL int     `toml:"failed_tasks_ttl"` // ttl for the lists that store failed tasks
-	BackoffEnabled bool    `toml:"backoff_enabled"`  // task-specific flag to disable/enable error-backoff
-	BackoffMin     int     `toml:"backoff_min"`      // task specific error-backoff start value in milliseconds
-	BackoffMax     int     `toml:"backoff_max"`      // task specific error-backoff maximum value in milliseconds
-	BackoffFactor  float64 `toml:"backoff_factor"`   // task specific error-backoff multiplicator
+	Type             string   // second part of the list-names used in Redis and used to identify tasks
+	Script           string   // path to the script/application that this task should execute
+	Workers          int      // number of concurrent go-routines available for this task
+	Cost             int      // share of the max_workers budget used by every running task of this type
+	Weight           int      // share of this task when fetching new tasks, relative to the other tasks
+	GlobalWorkers    int      `toml:"global_workers"`     // number of tasks of this type running at the same time across all instances
+	FailedTasksTTL   int      `toml:"failed_tasks_ttl"`   // ttl for the entries in the lists that store failed tasks
+	FailedTasksMax   int      `toml:"failed_tasks_max"`   // maximum length of the lists that store failed tasks
+	BackoffEnabled   bool     `toml:"backoff_enabled"`    // task-specific flag to disable/enable error-backoff
+	BackoffMin       int      `toml:"backoff_min"`        // task specific error-backoff start value in milliseconds
+	BackoffMax       int      `toml:"backoff_max"`        // task specific error-backoff maximum value in milliseconds
+	BackoffFactor    float64  `toml:"backoff_factor"`     // task specific error-backoff multiplicator
+	FailureMode      string   `toml:"failure_mode"`       // task specific condition for considering a task failed
+	OutputLimit      int      `toml:"output_limit"`       // task specific maximum number of bytes captured from stdout and stderr of a task
+	Timeout          int      `toml:"timeout"`            // task specific time in seconds after which the execution of a task is terminated
+	KillTimeout      int      `toml:"kill_timeout"`       // task specific time in seconds to wait after terminating a task, before killing it
+	RetryExitCodes   []int    `toml:"retry_exit_codes"`   // task specific exit-codes of tasks that request to be retried
+	DiscardExitCodes []int    `toml:"discard_exit_codes"` // task specific exit-codes of tasks that request to be discarded
+	MaxAttempts      int      `toml:"max_attempts"`       // task specific number of times a task is executed, before it is considered failed
+	MaxRetries       int      `toml:"max_retries"`        // task specific number of times a task is retried on request of its exit-code
+	RetryDelays      []int    `toml:"retry_delays"`       // task specific delays in seconds before retrying a failed task, per attempt
+	RetryExitDelays  []int    `toml:"-"`                  // the retry_delays used on request of the exit-code, which are all greater than 0
+	RateLimit        string   `toml:"rate_limit"`         // maximum number of tasks started per period across all instances, e.g. "50/minute"
+	RateBurst        int      `toml:"rate_burst"`         // number of tasks that may be started at once, within the rate limit
+	RatePerSecond    float64  `toml:"-"`                  // the rate limit converted to tasks per second, 0 if there is none
+	StoreResult      bool     `toml:"store_result"`       // flag to store the output of successful tasks as their result
+	ResultTTL        int      `toml:"result_ttl"`         // task specific time in seconds the result of a task is stored
+	StatusTTL        int      `toml:"status_ttl"`         // task specific time in seconds the status of a task is stored, 0 disables the tracking
+	StallTimeout     int      `toml:"stall_timeout"`      // task specific time in seconds without heartbeat, after which a running task is reported as stalled
+	UniqueTTL        int      `toml:"unique_ttl"`         // task specific time in seconds after which the uniqueness of a task expires
+	TTL              int      `toml:"ttl"`                // task specific time in seconds after which tasks expire, when they were not executed yet
+	ExpiredMode      string   `toml:"expired_mode"`       // task specific handling of tasks that expired
+	ExpiredTasksTTL  int      `toml:"expired_tasks_ttl"`  // task specific ttl for the entries in the lists that store expired tasks
+	ExpiredTasksMax  int      `toml:"expired_tasks_max"`  // task specific maximum length of the lists that store expired tasks
+	Unique           string   // rule for deriving the unique key of tasks from their arguments
+	Priorities       []string // names of the priorities of this task, ordered from highest to lowest
+	DefaultPriority  string   `toml:"default_priority"` // name of the priority whose list is named without suffix
+	PriorityMode     string   `toml:"priority_mode"`    // mode for draining the lists of the priorities
+	PriorityWeights  []int    `toml:"priority_weights"` // weights of the priorities, when using the weighted mode
+}
+
+// HasPriority returns true, if the given name is one of the priorities of the task.
+func (t Task) HasPriority(priority string) bool {
+	for _, p := range t.Priorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetryExitCode returns true, if a task exiting with the given code shall be retried.
+func (t Task) IsRetryExitCode(code int) bool {
+	return containsInt(t.RetryExitCodes, code)
+}
+
+// IsDiscardExitCode returns true, if a task exiting with the given code shall be discarded.
+func (t Task) IsDiscardExitCode(code int) bool {
+	return containsInt(t.DiscardExitCodes, code)
+}
+
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryDelay returns the delay in seconds before retrying a task that failed the given
+// number of times. The last configured delay is used for all further attempts.
+func (t Task) RetryDelay(attempts int) int {
+	return retryDelay(t.RetryDelays, attempts)
+}
+
+// RetryExitDelay returns the delay in seconds before retrying a task on request of its exit-code,
+// after the given number of attempts. Unlike RetryDelay it is never 0, so scripts reporting a
+// temporary problem are not executed again right away.
+func (t Task) RetryExitDelay(attempts int) int {
+	return retryDelay(t.RetryExitDelays, attempts)
+}
+
+func retryDelay(delays []int, attempts int) int {
+	if len(delays) == 0 || attempts < 1 {
+		return 0
+	}
+	if attempts > len(delays) {
+		attempts = len(delays)
+	}
+	return delays[attempts-1]
+}
+
+// A Cron stores information about a task that Gordon enqueues itself on a schedule.
+type Cron struct {
+	Name     string            // identifies the entry across all instances, defaults to "$task:$schedule"
+	Task     string            // type of the task that is enqueued
+	Schedule string            // cron expression defining when the task is enqueued
+	Timezone string            // name of the time zone the schedule is evaluated in, defaults to the local one
+	Args     []string          // arguments of the enqueued task
+	Env      map[string]string // environment variables of the enqueued task
+	Missed   string            // policy for ticks that were missed while no instance was running
 }
 
 // NewRelicConfig stores information for the agent.
@@ -72,6 +265,10 @@ func New(path string) (c Config, err error) {
 		c.RedisNetwork = "tcp"
 	}
 
+	if c.FetchMode != FetchModePoll && c.FetchMode != FetchModeNotify {
+		c.FetchMode = FetchModePoll
+	}
+
 	// ensure reasonable interval-values
 	if c.IntervalMin < 100 {
 		c.IntervalMin = 100
@@ -83,6 +280,23 @@ func New(path string) (c Config, err error) {
 		c.IntervalFactor = 1
 	}
 
+	if c.ShutdownTimeout < 0 {
+		c.ShutdownTimeout = 0
+	}
+
+	if c.MaxWorkers < 0 {
+		c.MaxWorkers = 0
+	}
+
+	if c.WorkflowTTL < 1 {
+		c.WorkflowTTL = 604800
+	}
+
+	// instances send heartbeats in a third of this interval
+	if c.InstanceTimeout < 1 {
+		c.InstanceTimeout = 30
+	}
+
 	// make sure that the backoff values are positive
 	if c.BackoffMin < 0 {
 		c.BackoffMin = 0
@@ -95,17 +309,37 @@ func New(path string) (c Config, err error) {
 	}
 
 	for taskType, task := range c.Tasks {
+		if reservedTaskTypes[taskType] {
+			err = fmt.Errorf("task %q: the name is reserved", taskType)
+			return
+		}
+
 		task.Type = taskType
 		task.Script = utils.Basepath(task.Script)
 
 		if task.Workers < 1 {
 			task.Workers = 1
 		}
+		if task.Weight < 1 {
+			task.Weight = 1
+		}
 
-		// override the failed-task-ttl if not set on this level
+		// tasks costing more than the budget could never be executed
+		if task.Cost < 1 {
+			task.Cost = 1
+		}
+		if c.MaxWorkers > 0 && task.Cost > c.MaxWorkers {
+			err = fmt.Errorf("task %q: cost %d exceeds max_workers %d", taskType, task.Cost, c.MaxWorkers)
+			return
+		}
+
+		// override the failed-task values if not set on this level
 		if task.FailedTasksTTL == 0 && c.FailedTasksTTL > 0 {
 			task.FailedTasksTTL = c.FailedTasksTTL
 		}
+		if task.FailedTasksMax == 0 && c.FailedTasksMax > 0 {
+			task.FailedTasksMax = c.FailedTasksMax
+		}
 
 		// if general error-backoff values are set, but not the task-specific
 		// ones, then we'll 'override' them here.
@@ -133,8 +367,267 @@ func New(path string) (c Config, err error) {
 			task.BackoffFactor = 1
 		}
 
+		// use the general output values, if not set on this level
+		if task.FailureMode == "" {
+			task.FailureMode = c.FailureMode
+		}
+		if task.FailureMode != FailureModeExitCode && task.FailureMode != FailureModeStdout && task.FailureMode != FailureModeStderr {
+			task.FailureMode = FailureModeStdout
+		}
+		if task.OutputLimit == 0 {
+			task.OutputLimit = c.OutputLimit
+		}
+		if task.OutputLimit < 1 {
+			task.OutputLimit = 65536
+		}
+
+		// use the general timeout values, if not set on this level
+		if task.Timeout == 0 {
+			task.Timeout = c.Timeout
+		}
+		if task.KillTimeout == 0 {
+			task.KillTimeout = c.KillTimeout
+		}
+		if task.KillTimeout < 1 {
+			task.KillTimeout = 10
+		}
+
+		// use the general exit-codes, if not set on this level
+		if len(task.RetryExitCodes) == 0 {
+			task.RetryExitCodes = c.RetryExitCodes
+		}
+		if len(task.DiscardExitCodes) == 0 {
+			task.DiscardExitCodes = c.DiscardExitCodes
+		}
+
+		// use the general retry values, if not set on this level
+		if task.MaxAttempts == 0 {
+			task.MaxAttempts = c.MaxAttempts
+		}
+		if task.MaxAttempts < 1 {
+			task.MaxAttempts = 1
+		}
+		if task.MaxRetries == 0 {
+			task.MaxRetries = c.MaxRetries
+		}
+		if task.MaxRetries < 1 {
+			task.MaxRetries = 10
+		}
+		if len(task.RetryDelays) == 0 {
+			task.RetryDelays = c.RetryDelays
+		}
+
+		// retries on request of the exit-code always wait, at least for the default delay
+		task.RetryExitDelays = []int{DefaultRetryExitDelay}
+		if len(task.RetryDelays) > 0 {
+			task.RetryExitDelays = make([]int, len(task.RetryDelays))
+			for i, delay := range task.RetryDelays {
+				if delay < 1 {
+					delay = DefaultRetryExitDelay
+				}
+				task.RetryExitDelays[i] = delay
+			}
+		}
+
+		// the output of tasks storing their result can not be a failure condition
+		if task.StoreResult && task.FailureMode == FailureModeStdout {
+			task.FailureMode = FailureModeExitCode
+		}
+		if task.ResultTTL == 0 {
+			task.ResultTTL = c.ResultTTL
+		}
+		if task.ResultTTL < 1 {
+			task.ResultTTL = 3600
+		}
+
+		// use the general status ttl, if not set on this level
+		if task.StatusTTL == 0 {
+			task.StatusTTL = c.StatusTTL
+		}
+		if task.StatusTTL < 0 {
+			task.StatusTTL = 0
+		}
+
+		// use the general expiry values, if not set on this level
+		if task.TTL == 0 {
+			task.TTL = c.TTL
+		}
+		if task.TTL < 0 {
+			task.TTL = 0
+		}
+		if task.ExpiredMode == "" {
+			task.ExpiredMode = c.ExpiredMode
+		}
+		if task.ExpiredMode != ExpiredModeList {
+			task.ExpiredMode = ExpiredModeDiscard
+		}
+
+		// the expired-lists are limited like the failed-lists, as they grow quickly after an outage
+		if task.ExpiredTasksTTL == 0 {
+			task.ExpiredTasksTTL = c.ExpiredTasksTTL
+		}
+		if task.ExpiredTasksTTL < 1 {
+			task.ExpiredTasksTTL = 604800
+		}
+		if task.ExpiredTasksMax == 0 {
+			task.ExpiredTasksMax = c.ExpiredTasksMax
+		}
+		if task.ExpiredTasksMax < 0 {
+			task.ExpiredTasksMax = 0
+		}
+
+		// use the general stall timeout, if not set on this level
+		if task.StallTimeout == 0 {
+			task.StallTimeout = c.StallTimeout
+		}
+		if task.StallTimeout < 0 {
+			task.StallTimeout = 0
+		}
+
+		// use the general uniqueness ttl, if not set on this level
+		if task.Unique != "" && task.Unique != UniqueArgs && task.Unique != UniquePayload {
+			err = fmt.Errorf("task %q: invalid unique rule %q", taskType, task.Unique)
+			return
+		}
+		if task.UniqueTTL == 0 {
+			task.UniqueTTL = c.UniqueTTL
+		}
+		if task.UniqueTTL < 1 {
+			task.UniqueTTL = 3600
+		}
+
+		if err = setupRateLimit(&task); err != nil {
+			err = fmt.Errorf("task %q: %s", taskType, err)
+			return
+		}
+
+		if err = setupPriorities(&task); err != nil {
+			err = fmt.Errorf("task %q: %s", taskType, err)
+			return
+		}
+
 		c.Tasks[taskType] = task
 	}
 
+	for i, cr := range c.Cron {
+		if _, ok := c.Tasks[cr.Task]; !ok {
+			err = fmt.Errorf("cron entry %d: task %q is not defined", i+1, cr.Task)
+			return
+		}
+
+		if _, err = cron.Parse(cr.Schedule); err != nil {
+			err = fmt.Errorf("cron entry %d: %s", i+1, err)
+			return
+		}
+
+		if cr.Timezone == "" {
+			cr.Timezone = "Local"
+		}
+		if _, err = time.LoadLocation(cr.Timezone); err != nil {
+			err = fmt.Errorf("cron entry %d: %s", i+1, err)
+			return
+		}
+
+		if cr.Missed == "" {
+			cr.Missed = CronMissedSkip
+		}
+		if cr.Missed != CronMissedSkip && cr.Missed != CronMissedOnce && cr.Missed != CronMissedAll {
+			err = fmt.Errorf("cron entry %d: invalid value %q for missed", i+1, cr.Missed)
+			return
+		}
+
+		if cr.Name == "" {
+			cr.Name = cr.Task + ":" + cr.Schedule
+		}
+
+		c.Cron[i] = cr
+	}
+
 	return
 }
+
+// rateLimitPeriods contains the units of the rate limits and their length in seconds.
+var rateLimitPeriods = map[string]float64{
+	"s":      1,
+	"sec":    1,
+	"second": 1,
+	"m":      60,
+	"min":    60,
+	"minute": 60,
+	"h":      3600,
+	"hour":   3600,
+}
+
+// setupRateLimit parses the rate limit of a task, which is given like "50/minute".
+func setupRateLimit(task *Task) error {
+	if task.RateLimit == "" {
+		return nil
+	}
+
+	parts := strings.SplitN(task.RateLimit, "/", 2)
+	count, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || count < 1 || len(parts) != 2 {
+		return fmt.Errorf("invalid rate_limit %q", task.RateLimit)
+	}
+
+	period, ok := rateLimitPeriods[strings.TrimSpace(parts[1])]
+	if !ok {
+		return fmt.Errorf("invalid period in rate_limit %q", task.RateLimit)
+	}
+
+	task.RatePerSecond = float64(count) / period
+	if task.RateBurst < 1 {
+		task.RateBurst = 1
+	}
+	return nil
+}
+
+// setupPriorities validates the priorities of a task and sets their default values.
+func setupPriorities(task *Task) error {
+	if task.DefaultPriority == "" {
+		task.DefaultPriority = DefaultPriority
+	}
+	if len(task.Priorities) == 0 {
+		task.Priorities = []string{task.DefaultPriority}
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range task.Priorities {
+		if p == "" || strings.Contains(p, ":") || reservedPriorities[p] {
+			return fmt.Errorf("invalid priority %q", p)
+		}
+		if seen[p] {
+			return fmt.Errorf("duplicate priority %q", p)
+		}
+		seen[p] = true
+	}
+
+	if !task.HasPriority(task.DefaultPriority) {
+		return fmt.Errorf("default_priority %q is not one of the priorities", task.DefaultPriority)
+	}
+
+	if task.PriorityMode == "" {
+		task.PriorityMode = PriorityModeStrict
+	}
+	if task.PriorityMode != PriorityModeStrict && task.PriorityMode != PriorityModeWeighted {
+		return fmt.Errorf("invalid priority_mode %q", task.PriorityMode)
+	}
+
+	// by default every priority gets twice the weight of the next lower one
+	if len(task.PriorityWeights) == 0 {
+		task.PriorityWeights = make([]int, len(task.Priorities))
+		for i := range task.Priorities {
+			task.PriorityWeights[i] = 1 << uint(len(task.Priorities)-i-1)
+		}
+	}
+	if len(task.PriorityWeights) != len(task.Priorities) {
+		return fmt.Errorf("priority_weights must have one value for every priority")
+	}
+	for _, w := range task.PriorityWeights {
+		if w < 1 {
+			return fmt.Errorf("priority_weights must be greater than 0")
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
@@ -37,3 +39,364 @@ func TestConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigCron(t *testing.T) {
+	path := writeTestConfig(t, `
+[tasks.something]
+script = "/bin/true"
+
+[[cron]]
+task = "something"
+schedule = "*/5 * * * *"
+`)
+	defer os.Remove(path)
+
+	conf, err := New(path)
+	if err != nil {
+		t.Log("New() should not return an error for a valid cron entry")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	cr := conf.Cron[0]
+	if cr.Name != "something:*/5 * * * *" || cr.Timezone != "Local" || cr.Missed != CronMissedSkip {
+		t.Log("New() should set the default values of cron entries")
+		t.Log("cron: ", cr)
+		t.Fail()
+	}
+
+	invalid := []string{
+		"[[cron]]\ntask = \"unknown\"\nschedule = \"* * * * *\"",
+		"[[cron]]\ntask = \"something\"\nschedule = \"* * *\"",
+		"[[cron]]\ntask = \"something\"\nschedule = \"* * * * *\"\ntimezone = \"Nowhere/Unknown\"",
+		"[[cron]]\ntask = \"something\"\nschedule = \"* * * * *\"\nmissed = \"sometimes\"",
+	}
+	for _, content := range invalid {
+		path := writeTestConfig(t, "[tasks.something]\nscript = \"/bin/true\"\n"+content)
+		_, err := New(path)
+		os.Remove(path)
+
+		if err == nil {
+			t.Log("New() should return an error for an invalid cron entry")
+			t.Log("content: ", content)
+			t.Fail()
+		}
+	}
+}
+
+func writeTestConfig(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "gordon.config")
+	if err != nil {
+		t.Log("ioutil.TempFile() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+	defer file.Close()
+
+	file.WriteString(content)
+	return file.Name()
+}
+
+func TestConfigRetry(t *testing.T) {
+	path := writeTestConfig(t, `
+max_attempts = 3
+retry_delays = [10, 60]
+
+[tasks.something]
+script = "/bin/true"
+
+[tasks.other]
+script = "/bin/true"
+max_attempts = 5
+retry_delays = [1]
+`)
+	defer os.Remove(path)
+
+	conf, err := New(path)
+	if err != nil {
+		t.Log("New() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	task := conf.Tasks["something"]
+	if task.MaxAttempts != 3 || len(task.RetryDelays) != 2 {
+		t.Log("The general retry values should be used, when not set on task-level")
+		t.Fail()
+	}
+	if task.RetryDelay(1) != 10 || task.RetryDelay(2) != 60 || task.RetryDelay(5) != 60 {
+		t.Log("RetryDelay() should return the delay for the attempt, or the last one")
+		t.Fail()
+	}
+
+	task = conf.Tasks["other"]
+	if task.MaxAttempts != 5 || task.RetryDelay(3) != 1 {
+		t.Log("The task specific retry values should not be overridden")
+		t.Fail()
+	}
+	if (Task{}).RetryDelay(1) != 0 {
+		t.Log("RetryDelay() should return 0, when no delays are configured")
+		t.Fail()
+	}
+}
+
+func TestConfigExitCodes(t *testing.T) {
+	path := writeTestConfig(t, `
+retry_exit_codes = [75]
+
+[tasks.something]
+script = "/bin/true"
+
+[tasks.other]
+script = "/bin/true"
+retry_exit_codes = [69]
+discard_exit_codes = [65, 66]
+max_retries = 3
+retry_delays = [0, 30]
+`)
+	defer os.Remove(path)
+
+	conf, err := New(path)
+	if err != nil {
+		t.Log("New() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	task := conf.Tasks["something"]
+	if !task.IsRetryExitCode(75) || task.IsRetryExitCode(1) || task.IsDiscardExitCode(65) {
+		t.Log("The general exit-codes should be used, when not set on task-level")
+		t.Fail()
+	}
+
+	task = conf.Tasks["other"]
+	if task.IsRetryExitCode(75) || !task.IsRetryExitCode(69) || !task.IsDiscardExitCode(66) {
+		t.Log("The task specific exit-codes should not be overridden")
+		t.Fail()
+	}
+
+	if conf.Tasks["something"].RetryExitDelay(1) != DefaultRetryExitDelay || task.RetryExitDelay(2) != 30 || task.RetryDelay(1) != 0 {
+		t.Log("The retries on request of the exit-code should use the retry_delays greater than 0, or the default delay")
+		t.Log("RetryExitDelays: ", conf.Tasks["something"].RetryExitDelays, task.RetryExitDelays)
+		t.Fail()
+	}
+
+	if conf.Tasks["something"].MaxRetries != 10 || task.MaxRetries != 3 {
+		t.Log("The retries on request of the exit-code should be limited, with a default of 10")
+		t.Log("MaxRetries: ", conf.Tasks["something"].MaxRetries, task.MaxRetries)
+		t.Fail()
+	}
+}
+
+func TestConfigPriorities(t *testing.T) {
+	path := writeTestConfig(t, `
+[tasks.something]
+script = "/bin/true"
+
+[tasks.other]
+script = "/bin/true"
+priorities = ["high", "normal", "low"]
+priority_mode = "weighted"
+`)
+	defer os.Remove(path)
+
+	conf, err := New(path)
+	if err != nil {
+		t.Log("New() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	task := conf.Tasks["something"]
+	if len(task.Priorities) != 1 || task.Priorities[0] != DefaultPriority || task.PriorityMode != PriorityModeStrict {
+		t.Log("Tasks without priorities should have the default priority only")
+		t.Fail()
+	}
+
+	task = conf.Tasks["other"]
+	if len(task.PriorityWeights) != 3 || task.PriorityWeights[0] != 4 || task.PriorityWeights[2] != 1 {
+		t.Log("The default weights should double for every higher priority")
+		t.Log("weights: ", task.PriorityWeights)
+		t.Fail()
+	}
+	if !task.HasPriority("low") || task.HasPriority("urgent") {
+		t.Log("HasPriority() should check for configured priorities")
+		t.Fail()
+	}
+
+	invalid := []string{
+		`priorities = ["high", "low"]`,
+		`priorities = ["high", "normal", "high"]`,
+		`priorities = ["failed", "normal"]`,
+		`priorities = ["high", "normal"]` + "\npriority_weights = [1]",
+		`priorities = ["high", "normal"]` + "\npriority_mode = \"random\"",
+	}
+	for _, content := range invalid {
+		path := writeTestConfig(t, "[tasks.something]\nscript = \"/bin/true\"\n"+content)
+		_, err := New(path)
+		os.Remove(path)
+
+		if err == nil {
+			t.Log("New() should return an error for invalid priorities")
+			t.Log("content: ", content)
+			t.Fail()
+		}
+	}
+}
+
+func TestConfigReservedTaskTypes(t *testing.T) {
+	for _, taskType := range []string{"workflows", "instances", "waiting", "concurrency", "task", "cron"} {
+		path := writeTestConfig(t, "[tasks."+taskType+"]\nscript = \"/bin/true\"\n")
+		_, err := New(path)
+		os.Remove(path)
+
+		if err == nil {
+			t.Log("New() should return an error for task types that are used by global keys")
+			t.Log("type: ", taskType)
+			t.Fail()
+		}
+	}
+}
+
+func TestConfigUnique(t *testing.T) {
+	path := writeTestConfig(t, `
+unique_ttl = 600
+
+[tasks.something]
+script = "/bin/true"
+unique = "args"
+
+[tasks.other]
+script = "/bin/true"
+unique_ttl = 60
+`)
+	defer os.Remove(path)
+
+	conf, err := New(path)
+	if err != nil {
+		t.Log("New() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	if conf.Tasks["something"].UniqueTTL != 600 || conf.Tasks["other"].UniqueTTL != 60 {
+		t.Log("The general unique_ttl should be used, when not set on task-level")
+		t.Fail()
+	}
+
+	path = writeTestConfig(t, "[tasks.something]\nscript = \"/bin/true\"\nunique = \"everything\"")
+	defer os.Remove(path)
+
+	if _, err = New(path); err == nil {
+		t.Log("New() should return an error for an invalid unique rule")
+		t.Fail()
+	}
+}
+
+func TestConfigRateLimit(t *testing.T) {
+	path := writeTestConfig(t, `
+[tasks.something]
+script = "/bin/true"
+rate_limit = "50/minute"
+rate_burst = 10
+
+[tasks.other]
+script = "/bin/true"
+rate_limit = "2/s"
+
+[tasks.unlimited]
+script = "/bin/true"
+`)
+	defer os.Remove(path)
+
+	conf, err := New(path)
+	if err != nil {
+		t.Log("New() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	task := conf.Tasks["something"]
+	if task.RatePerSecond != 50.0/60 || task.RateBurst != 10 {
+		t.Log("The rate limit should be converted to tasks per second")
+		t.Log("RatePerSecond: ", task.RatePerSecond, "RateBurst: ", task.RateBurst)
+		t.Fail()
+	}
+
+	task = conf.Tasks["other"]
+	if task.RatePerSecond != 2 || task.RateBurst != 1 {
+		t.Log("The burst should default to 1")
+		t.Fail()
+	}
+
+	if conf.Tasks["unlimited"].RatePerSecond != 0 {
+		t.Log("Tasks without rate limit should have a rate of 0")
+		t.Fail()
+	}
+
+	for _, limit := range []string{"50", "50/fortnight", "x/minute", "0/minute"} {
+		path := writeTestConfig(t, "[tasks.something]\nscript = \"/bin/true\"\nrate_limit = \""+limit+"\"")
+		_, err := New(path)
+		os.Remove(path)
+
+		if err == nil {
+			t.Log("New() should return an error for an invalid rate_limit")
+			t.Log("rate_limit: ", limit)
+			t.Fail()
+		}
+	}
+}
+
+func TestConfigExpiry(t *testing.T) {
+	path := writeTestConfig(t, `
+ttl = 600
+expired_mode = "list"
+expired_tasks_max = 1000
+
+[tasks.something]
+script = "/bin/true"
+
+[tasks.other]
+script = "/bin/true"
+ttl = 30
+expired_mode = "invalid"
+expired_tasks_ttl = 3600
+
+[tasks.forever]
+script = "/bin/true"
+ttl = -1
+`)
+	defer os.Remove(path)
+
+	conf, err := New(path)
+	if err != nil {
+		t.Log("New() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	task := conf.Tasks["something"]
+	if task.TTL != 600 || task.ExpiredMode != ExpiredModeList {
+		t.Log("Tasks should use the general expiry values")
+		t.Log("TTL: ", task.TTL, "ExpiredMode: ", task.ExpiredMode)
+		t.Fail()
+	}
+
+	task = conf.Tasks["other"]
+	if task.TTL != 30 || task.ExpiredMode != ExpiredModeDiscard {
+		t.Log("Tasks should use their own ttl, and discard expired tasks on an invalid expired_mode")
+		t.Log("TTL: ", task.TTL, "ExpiredMode: ", task.ExpiredMode)
+		t.Fail()
+	}
+
+	if conf.Tasks["something"].ExpiredTasksTTL != 604800 || conf.Tasks["something"].ExpiredTasksMax != 1000 || task.ExpiredTasksTTL != 3600 {
+		t.Log("The expired-lists should be limited, with a default ttl of 7 days")
+		t.Log("ExpiredTasksTTL: ", conf.Tasks["something"].ExpiredTasksTTL, task.ExpiredTasksTTL)
+		t.Fail()
+	}
+
+	if conf.Tasks["forever"].TTL != 0 {
+		t.Log("A negative ttl should disable the expiry")
+		t.Fail()
+	}
+}
@@ -0,0 +1,232 @@
+// Package cron provides functionality to parse cron expressions and to calculate
+// the times at which they are activated.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule stores the parsed fields of a cron expression as bitsets.
+type Schedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool // day-of-month was "*", so only day-of-week restricts the days
+	dowStar bool // day-of-week was "*", so only day-of-month restricts the days
+}
+
+type bounds struct {
+	min   uint
+	max   uint
+	names map[string]uint
+}
+
+var (
+	minuteBounds = bounds{0, 59, nil}
+	hourBounds   = bounds{0, 23, nil}
+	domBounds    = bounds{1, 31, nil}
+	monthBounds  = bounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowBounds = bounds{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// macros contains the supported shortcuts and the expressions they stand for.
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression with the five fields minute, hour, day-of-month,
+// month and day-of-week, or one of the macros like "@daily".
+// It returns an error when the expression is invalid.
+func Parse(expr string) (s Schedule, err error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		err = fmt.Errorf("cron expression %q must have 5 fields, has %d", expr, len(fields))
+		return
+	}
+
+	if s.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return
+	}
+	if s.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return
+	}
+	if s.dom, err = parseField(fields[2], domBounds); err != nil {
+		return
+	}
+	if s.month, err = parseField(fields[3], monthBounds); err != nil {
+		return
+	}
+	if s.dow, err = parseField(fields[4], dowBounds); err != nil {
+		return
+	}
+
+	// sunday may be written as 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow = (s.dow | 1) &^ (1 << 7)
+	}
+
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	return
+}
+
+// parseField parses a comma-separated list of values, ranges and steps into a bitset.
+func parseField(field string, b bounds) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		var partBits uint64
+		partBits, err = parsePart(part, b)
+		if err != nil {
+			return
+		}
+		bits |= partBits
+	}
+	return
+}
+
+func parsePart(part string, b bounds) (bits uint64, err error) {
+	rangeAndStep := strings.SplitN(part, "/", 2)
+	start, end := b.min, b.max
+	step := uint(1)
+
+	if rangeAndStep[0] != "*" {
+		lowAndHigh := strings.SplitN(rangeAndStep[0], "-", 2)
+		if start, err = parseValue(lowAndHigh[0], b); err != nil {
+			return
+		}
+
+		switch {
+		case len(lowAndHigh) == 2:
+			if end, err = parseValue(lowAndHigh[1], b); err != nil {
+				return
+			}
+		case len(rangeAndStep) == 1:
+			end = start
+		}
+	}
+
+	if len(rangeAndStep) == 2 {
+		var n int
+		n, err = strconv.Atoi(rangeAndStep[1])
+		if err != nil || n < 1 {
+			err = fmt.Errorf("invalid step in %q", part)
+			return
+		}
+		step = uint(n)
+	}
+
+	if start > end {
+		err = fmt.Errorf("invalid range in %q", part)
+		return
+	}
+
+	for i := start; i <= end; i += step {
+		bits |= 1 << i
+	}
+	return
+}
+
+func parseValue(value string, b bounds) (uint, error) {
+	if n, ok := b.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < int(b.min) || n > int(b.max) {
+		return 0, fmt.Errorf("invalid value %q, must be between %d and %d", value, b.min, b.max)
+	}
+
+	return uint(n), nil
+}
+
+// Next returns the first activation time of the schedule after the passed time,
+// in the location of the passed time. It returns the zero time, when there is no
+// activation within the next five years (e.g. for "0 0 30 2 *").
+func (s Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+	// truncated is true, once the smaller units were set to their minimum
+	truncated := false
+
+wrap:
+	for t.Year() <= yearLimit {
+		for s.month&(1<<uint(t.Month())) == 0 {
+			if !truncated {
+				truncated = true
+				t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+			}
+			t = t.AddDate(0, 1, 0)
+			if t.Month() == time.January {
+				continue wrap
+			}
+		}
+
+		for !s.dayMatches(t) {
+			if !truncated {
+				truncated = true
+				t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+			}
+			t = t.AddDate(0, 0, 1)
+			if t.Day() == 1 {
+				continue wrap
+			}
+		}
+
+		for s.hour&(1<<uint(t.Hour())) == 0 {
+			if !truncated {
+				truncated = true
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+			}
+			t = t.Add(time.Hour)
+			if t.Hour() == 0 {
+				continue wrap
+			}
+		}
+
+		for s.minute&(1<<uint(t.Minute())) == 0 {
+			truncated = true
+			t = t.Add(time.Minute)
+			if t.Minute() == 0 {
+				continue wrap
+			}
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches checks the day-of-month and day-of-week fields. When both are restricted,
+// a day matches if any of them does, like in the original cron implementation.
+func (s Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,99 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	valid := []string{
+		"* * * * *",
+		"*/5 * * * *",
+		"0 3 * * mon-fri",
+		"15,45 8-18/2 1 jan,jul *",
+		"0 0 * * 7",
+		"@daily",
+		"@Hourly",
+	}
+	for _, expr := range valid {
+		if _, err := Parse(expr); err != nil {
+			t.Log("Parse() should not return an error for a valid expression")
+			t.Log("expr:", expr, "err:", err)
+			t.Fail()
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@often",
+	}
+	for _, expr := range invalid {
+		if _, err := Parse(expr); err == nil {
+			t.Log("Parse() should return an error for an invalid expression")
+			t.Log("expr:", expr)
+			t.Fail()
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2017-06-30 was a friday
+	from := time.Date(2017, 6, 30, 14, 46, 40, 0, time.UTC)
+
+	expected := map[string]time.Time{
+		"* * * * *":        time.Date(2017, 6, 30, 14, 47, 0, 0, time.UTC),
+		"*/15 * * * *":     time.Date(2017, 6, 30, 15, 0, 0, 0, time.UTC),
+		"0 3 * * *":        time.Date(2017, 7, 1, 3, 0, 0, 0, time.UTC),
+		"0 3 * * mon-fri":  time.Date(2017, 7, 3, 3, 0, 0, 0, time.UTC),
+		"0 0 * * 0":        time.Date(2017, 7, 2, 0, 0, 0, 0, time.UTC),
+		"0 0 * * 7":        time.Date(2017, 7, 2, 0, 0, 0, 0, time.UTC),
+		"30 12 1 * *":      time.Date(2017, 7, 1, 12, 30, 0, 0, time.UTC),
+		"0 0 1 1 *":        time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 13 * fri":     time.Date(2017, 7, 7, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":       time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC),
+		"46 14 30 6 *":     time.Date(2018, 6, 30, 14, 46, 0, 0, time.UTC),
+		"@hourly":          time.Date(2017, 6, 30, 15, 0, 0, 0, time.UTC),
+		"0 0 30 2 *":       {},
+		"5/20 9-10 * * *":  time.Date(2017, 7, 1, 9, 5, 0, 0, time.UTC),
+		"50 14,16 * * fri": time.Date(2017, 6, 30, 14, 50, 0, 0, time.UTC),
+	}
+
+	for expr, want := range expected {
+		s, err := Parse(expr)
+		if err != nil {
+			t.Log("Parse() should not return an error for a valid expression")
+			t.Log("expr:", expr, "err:", err)
+			t.Fail()
+			continue
+		}
+
+		if got := s.Next(from); !got.Equal(want) {
+			t.Log("Next() should return the next activation time")
+			t.Log("expr:", expr, "expected:", want, "returned:", got)
+			t.Fail()
+		}
+	}
+}
+
+func TestNextLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	from := time.Date(2017, 6, 30, 23, 30, 0, 0, time.UTC)
+
+	s, _ := Parse("0 3 * * *")
+	next := s.Next(from.In(loc))
+	if !next.Equal(time.Date(2017, 7, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Log("Next() should calculate the activation time in the location of the passed time")
+		t.Log("returned:", next)
+		t.Fail()
+	}
+}
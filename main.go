@@ -103,7 +103,7 @@ func main() {
 
 	// Start another go-routine to initiate the graceful shutdown of all taskqueue-workers,
 	// when the application shall be terminated.
-	cc := make(chan os.Signal)
+	cc := make(chan os.Signal, 1)
 	signal.Notify(cc, os.Interrupt, os.Kill, syscall.SIGTERM)
 	go func() {
 		<-cc
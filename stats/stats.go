@@ -8,6 +8,7 @@ import (
 	"github.com/nevsnode/gordon/output"
 	"github.com/newrelic/go-agent"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 )
@@ -18,30 +19,48 @@ var (
 	// GordonVersion contains the current version of gordon
 	GordonVersion = ""
 
-	runtimeStart = getNowUnix()
-	taskCounter  = newTaskCount()
-	newRelicApp  newrelic.Application
+	runtimeStart   = getNowUnix()
+	taskCounter    = newTaskCount()
+	expiredCounter = newTaskCount()
+	runningTasks   = newRunningTaskList()
+	waitTimes      = newTaskTimings()
+	runTimes       = newTaskTimings()
+	newRelicApp    newrelic.Application
 )
 
 // statsResponse is the response that will be returned from the HTTP-server,
 // containing the statistical data.
 type statsResponse struct {
-	Runtime   int64            `json:"runtime"`
-	TaskCount map[string]int64 `json:"task_count"`
-	Version   string           `json:"version"`
+	Runtime      int64             `json:"runtime"`
+	TaskCount    map[string]int64  `json:"task_count"`
+	ExpiredCount map[string]int64  `json:"expired_count"`
+	WaitTime     map[string]timing `json:"wait_time"`
+	RunTime      map[string]timing `json:"run_time"`
+	RunningTasks []runningTask     `json:"running_tasks"`
+	Version      string            `json:"version"`
 }
 
 // InitTasks initialises the counters for the defined task-types.
 func InitTasks(tasks map[string]config.Task) {
 	for taskType := range tasks {
 		taskCounter.Init(taskType)
+		expiredCounter.Init(taskType)
+		waitTimes.Init(taskType)
+		runTimes.Init(taskType)
 	}
 }
 
 // StartedTask handles stats when a task was started.
 func StartedTask(task string) Transaction {
 	taskCounter.Increment(task)
-	return NewTransaction(task)
+	t := NewTransaction(task)
+	t.running = runningTasks.Add(task)
+	return t
+}
+
+// ExpiredTask handles stats when a task expired, instead of being executed.
+func ExpiredTask(task string) {
+	expiredCounter.Increment(task)
 }
 
 // Setup will initialize the stats-package to be able to record
@@ -84,14 +103,18 @@ func httpHandle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf8")
-	fmt.Fprintf(w, fmt.Sprintf("%s", b))
+	fmt.Fprintf(w, "%s", b)
 }
 
 func getStats() statsResponse {
 	return statsResponse{
-		Runtime:   getRuntime(),
-		TaskCount: taskCounter.GetTaskCount(),
-		Version:   GordonVersion,
+		Runtime:      getRuntime(),
+		TaskCount:    taskCounter.GetTaskCount(),
+		ExpiredCount: expiredCounter.GetTaskCount(),
+		WaitTime:     waitTimes.GetTimings(),
+		RunTime:      runTimes.GetTimings(),
+		RunningTasks: runningTasks.GetRunningTasks(),
+		Version:      GordonVersion,
 	}
 }
 
@@ -132,8 +155,132 @@ func (t *taskCount) GetTaskCount() map[string]int64 {
 	return t.counts
 }
 
+// A timing summarizes the durations of tasks in seconds.
+type timing struct {
+	Count   int64   `json:"count"`
+	Total   float64 `json:"total"`
+	Average float64 `json:"average"`
+	Max     float64 `json:"max"`
+}
+
+func newTaskTimings() *taskTimings {
+	return &taskTimings{
+		timings: make(map[string]timing),
+	}
+}
+
+type taskTimings struct {
+	timings map[string]timing
+	mutex   sync.RWMutex
+}
+
+func (t *taskTimings) Init(task string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.timings[task] = timing{}
+}
+
+func (t *taskTimings) Add(task string, d time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	seconds := d.Seconds()
+	tm := t.timings[task]
+	tm.Count++
+	tm.Total += seconds
+	tm.Average = tm.Total / float64(tm.Count)
+	if seconds > tm.Max {
+		tm.Max = seconds
+	}
+	t.timings[task] = tm
+}
+
+func (t *taskTimings) GetTimings() map[string]timing {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	timings := make(map[string]timing, len(t.timings))
+	for task, tm := range t.timings {
+		timings[task] = tm
+	}
+	return timings
+}
+
+// Progress is the progress a running task reported.
+type Progress struct {
+	Percent     float64 `json:"progress"`          // percentage of the work that is done
+	Message     string  `json:"message,omitempty"` // text describing what the task is doing
+	HeartbeatAt int64   `json:"heartbeat_at"`      // unix timestamp of the last sign of life of the task
+	Stalled     bool    `json:"stalled"`           // true if the task did not send a heartbeat for too long
+}
+
+// A runningTask is a task being executed, as listed in the statistics.
+type runningTask struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	StartedAt int64  `json:"started_at"`
+	Progress
+}
+
+func newRunningTaskList() *runningTaskList {
+	return &runningTaskList{
+		tasks: make(map[*runningTask]bool),
+	}
+}
+
+type runningTaskList struct {
+	tasks map[*runningTask]bool
+	mutex sync.RWMutex
+}
+
+func (r *runningTaskList) Add(task string) *runningTask {
+	now := getNowUnix()
+	rt := &runningTask{
+		Type:      task,
+		StartedAt: now,
+		Progress:  Progress{HeartbeatAt: now},
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tasks[rt] = true
+	return rt
+}
+
+func (r *runningTaskList) Remove(rt *runningTask) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.tasks, rt)
+}
+
+func (r *runningTaskList) Update(rt *runningTask, update func(rt *runningTask)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	update(rt)
+}
+
+func (r *runningTaskList) GetRunningTasks() []runningTask {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	tasks := make([]runningTask, 0, len(r.tasks))
+	for rt := range r.tasks {
+		tasks = append(tasks, *rt)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].StartedAt != tasks[j].StartedAt {
+			return tasks[i].StartedAt < tasks[j].StartedAt
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	return tasks
+}
+
 // NewTransaction creates and returns a new transaction instance.
 func NewTransaction(name string) (t Transaction) {
+	t.task = name
+	t.started = time.Now()
+
 	if newRelicApp != nil {
 		t.hasNrTxn = true
 		t.nrTxn = newRelicApp.StartTransaction(name, nil, nil)
@@ -146,14 +293,60 @@ func NewTransaction(name string) (t Transaction) {
 type Transaction struct {
 	hasNrTxn bool
 	nrTxn    newrelic.Transaction
+	running  *runningTask
+	task     string
+	started  time.Time
+}
+
+// SetWaitTime records the time the task waited in its queue, before it was started.
+func (t Transaction) SetWaitTime(d time.Duration) {
+	waitTimes.Add(t.task, d)
+
+	if t.hasNrTxn {
+		t.nrTxn.AddAttribute("waitTime", d.Seconds())
+	}
+}
+
+// SetTaskID sets the id of the task, under which it is listed as running task.
+func (t Transaction) SetTaskID(id string) {
+	if t.running == nil {
+		return
+	}
+
+	runningTasks.Update(t.running, func(rt *runningTask) {
+		rt.ID = id
+	})
+}
+
+// SetProgress sets the progress of the task, which is listed as running task.
+func (t Transaction) SetProgress(p Progress) {
+	if t.running == nil {
+		return
+	}
+
+	runningTasks.Update(t.running, func(rt *runningTask) {
+		rt.Progress = p
+	})
 }
 
 // End will mark the end of the execution of a task.
 func (t Transaction) End() {
+	if t.running != nil {
+		runningTasks.Remove(t.running)
+	}
+
+	if t.started.IsZero() {
+		return
+	}
+
+	runTime := time.Since(t.started)
+	runTimes.Add(t.task, runTime)
+
 	if !t.hasNrTxn {
 		return
 	}
 
+	t.nrTxn.AddAttribute("runTime", runTime.Seconds())
 	t.nrTxn.End()
 }
 
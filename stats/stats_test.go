@@ -106,3 +106,74 @@ func TestStatsHttp(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestStatsRunningTasks(t *testing.T) {
+	txn := StartedTask(testTaskType)
+	txn.SetTaskID("running-task")
+	txn.SetProgress(Progress{Percent: 42, Message: "importing", HeartbeatAt: 123})
+
+	var found *runningTask
+	for _, rt := range getStats().RunningTasks {
+		if rt.ID == "running-task" {
+			found = &rt
+		}
+	}
+	if found == nil {
+		t.Log("getStats() should list a started task as running task")
+		t.FailNow()
+	}
+	if found.Type != testTaskType || found.Percent != 42 || found.Message != "importing" || found.HeartbeatAt != 123 {
+		t.Log("getStats() should contain the progress of a running task")
+		t.Log("running task:", *found)
+		t.Fail()
+	}
+
+	txn.End()
+	for _, rt := range getStats().RunningTasks {
+		if rt.ID == "running-task" {
+			t.Log("getStats() should not list a task as running task, after its transaction ended")
+			t.Fail()
+		}
+	}
+}
+
+func TestStatsExpired(t *testing.T) {
+	InitTasks(map[string]config.Task{"expiring": {Type: "expiring"}})
+	ExpiredTask("expiring")
+	ExpiredTask("expiring")
+
+	sr := getStats()
+	if sr.ExpiredCount["expiring"] != 2 {
+		t.Log("The expired-count should be incremented for every expired task")
+		t.Log("expired-count: ", sr.ExpiredCount)
+		t.Fail()
+	}
+	if sr.TaskCount["expiring"] != 0 {
+		t.Log("Expired tasks should not be counted as started tasks")
+		t.Fail()
+	}
+}
+
+func TestStatsTimings(t *testing.T) {
+	InitTasks(map[string]config.Task{"timed": {Type: "timed"}})
+
+	txn := NewTransaction("timed")
+	txn.SetWaitTime(2 * time.Second)
+	txn.SetWaitTime(4 * time.Second)
+	txn.End()
+
+	sr := getStats()
+	expected := timing{Count: 2, Total: 6, Average: 3, Max: 4}
+	if sr.WaitTime["timed"] != expected {
+		t.Log("The wait-time should summarize the recorded durations")
+		t.Log("expected: ", expected)
+		t.Log("returned: ", sr.WaitTime["timed"])
+		t.Fail()
+	}
+
+	if run := sr.RunTime["timed"]; run.Count != 1 || run.Max > 1 {
+		t.Log("The run-time should be recorded when the transaction ends")
+		t.Log("returned: ", run)
+		t.Fail()
+	}
+}
@@ -0,0 +1,128 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for cancelling tasks by their id, which skips queued tasks
+// and terminates running ones.
+package taskqueue
+
+import (
+	"fmt"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"sync"
+	"time"
+)
+
+var (
+	errorTaskCancelled = fmt.Errorf("Task was cancelled")
+
+	cancellableTasks     = make(map[chan error]string) // ids of the running tasks by the channels receiving the reason when cancelled
+	cancellableTasksLock sync.Mutex
+)
+
+func cancelKey(id string) string {
+	return conf.RedisQueueKey + ":cancel:" + id
+}
+
+// isTaskCancelled returns true, if a client requested to cancel the task.
+func isTaskCancelled(task QueueTask) (bool, error) {
+	if task.ID == "" {
+		return false, nil
+	}
+
+	cancelled, err := redisPoolCmd(3, "EXISTS", cancelKey(task.ID)).Int()
+	return cancelled == 1, err
+}
+
+// watchCancellation returns a channel receiving the reason for terminating a running task,
+// when it is cancelled. Tasks without id can not be cancelled, so nil is returned.
+func watchCancellation(task QueueTask) chan error {
+	if task.ID == "" {
+		return nil
+	}
+
+	cancel := make(chan error, 1)
+	cancellableTasksLock.Lock()
+	cancellableTasks[cancel] = task.ID
+	cancellableTasksLock.Unlock()
+	return cancel
+}
+
+// unwatchCancellation stops watching for the cancellation of a task, after its execution finished.
+func unwatchCancellation(cancel chan error) {
+	if cancel == nil {
+		return
+	}
+
+	cancellableTasksLock.Lock()
+	delete(cancellableTasks, cancel)
+	cancellableTasksLock.Unlock()
+}
+
+// cancelWorker periodically checks whether running tasks were cancelled, and terminates them.
+// It keeps running during the shutdown, until all task workers finished.
+func cancelWorker() {
+	defer waitGroupTasksDone.Done()
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cancelRunningTasks()
+		case <-tasksDoneChan:
+			output.Debug("Finished cancel-worker")
+			return
+		}
+	}
+}
+
+// cancelRunningTasks terminates the running tasks, for which a cancellation was requested.
+func cancelRunningTasks() {
+	cancellableTasksLock.Lock()
+	channels := make([]chan error, 0, len(cancellableTasks))
+	keys := make([]string, 0, len(cancellableTasks))
+	for cancel, id := range cancellableTasks {
+		channels = append(channels, cancel)
+		keys = append(keys, cancelKey(id))
+	}
+	cancellableTasksLock.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+
+	values, err := redisPoolCmd(1, "MGET", keys).Array()
+	if err != nil {
+		output.NotifyError("cancelRunningTasks(), MGET:", err)
+		return
+	}
+
+	for i, value := range values {
+		if value.IsType(redis.Nil) {
+			continue
+		}
+
+		// the channel is buffered, and only the first reason terminates the task
+		output.Debug("Cancelling running task with key", keys[i])
+		select {
+		case channels[i] <- errorTaskCancelled:
+		default:
+		}
+	}
+}
+
+// finishCancelledTask removes a cancelled task from its processing-list, after its
+// status was stored, and handles it like a task that will not be executed anymore.
+func finishCancelledTask(pt processingTask, task QueueTask, ct config.Task, exitCode int) {
+	storeTaskResult(task, ct, statusCancelled, nil, exitCode)
+	finishWorkflowStep(task, statusCancelled)
+	releaseUniqueTask(task, ct)
+	finishTask(pt)
+
+	// the request was fulfilled, so the id can be used again
+	reply := redisPoolCmd(3, "DEL", cancelKey(task.ID))
+	if reply.Err != nil {
+		output.NotifyError("finishCancelledTask(), DEL:", reply.Err, "\nTask:", task.ID)
+	}
+}
@@ -0,0 +1,78 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for side-channels, which are additional file descriptors
+// scripts can write structured records to, besides stdout and stderr.
+package taskqueue
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	sideChannelMaxLine = 1024 * 1024 // maximum length of a line written to a side-channel
+	sideChannelLinger  = time.Second // time to wait for remaining lines, after the script exited
+)
+
+// A sideChannel is a pipe passed to a script as additional file descriptor.
+// Every line written to it is passed to a handler, while the script is running.
+type sideChannel struct {
+	reader *os.File
+	writer *os.File
+	done   chan bool
+	err    error
+}
+
+// newSideChannel adds a pipe to the files of the command, which is passed as file descriptor
+// to the script. Its number is stored in the passed environment variable of the command.
+func newSideChannel(cmd *exec.Cmd, env string, handle func(line []byte)) (*sideChannel, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	// the first 3 file descriptors are stdin, stdout and stderr
+	cmd.ExtraFiles = append(cmd.ExtraFiles, writer)
+	cmd.Env = append(cmd.Env, env+"="+strconv.Itoa(len(cmd.ExtraFiles)+2))
+
+	c := &sideChannel{
+		reader: reader,
+		writer: writer,
+		done:   make(chan bool),
+	}
+
+	go func() {
+		defer close(c.done)
+
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 4096), sideChannelMaxLine)
+		for scanner.Scan() {
+			handle(scanner.Bytes())
+		}
+		c.err = scanner.Err()
+	}()
+
+	return c, nil
+}
+
+// started closes the writing end of the pipe in this process, after the script was started.
+func (c *sideChannel) started() {
+	c.writer.Close()
+}
+
+// close waits until all lines were read after the script exited, and returns the error that
+// occurred while reading. Child processes of the script might still hold the pipe open, so
+// lines written after sideChannelLinger are discarded.
+func (c *sideChannel) close() error {
+	c.writer.Close()
+	c.reader.SetReadDeadline(time.Now().Add(sideChannelLinger))
+	<-c.done
+	c.reader.Close()
+
+	if os.IsTimeout(c.err) {
+		return nil
+	}
+	return c.err
+}
@@ -0,0 +1,193 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for tasks with a concurrency key, of which only one is
+// executed at a time across all instances, while the others are waiting.
+package taskqueue
+
+import (
+	"encoding/json"
+	"github.com/nevsnode/gordon/output"
+	"strings"
+	"sync"
+)
+
+// concurrencyAcquireScript acquires the lease (KEYS[1]) of a concurrency key with the token in ARGV[1]
+// and the ttl in ARGV[2]. When another task holds the lease, the task (ARGV[3]) is moved from the
+// processing-list (KEYS[2]) to the waiting-list (KEYS[3]) of the key as entry ARGV[4], and the
+// waiting-list is added to the set of all waiting-lists (KEYS[4]).
+// It returns 1 when the lease was acquired.
+const concurrencyAcquireScript = `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'EX', ARGV[2]) then
+	return 1
+end
+
+if redis.call('LREM', KEYS[2], 1, ARGV[3]) > 0 then
+	redis.call('RPUSH', KEYS[3], ARGV[4])
+	redis.call('SADD', KEYS[4], KEYS[3])
+end
+return 0
+`
+
+// concurrencyReleaseScript releases the lease (KEYS[1]) of a concurrency key, if it is held
+// by the token in ARGV[1]. When the lease is free, the next waiting task from the waiting-list
+// (KEYS[2]) is moved back to the head of the list it was fetched from.
+const concurrencyReleaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+end
+
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	local entry = redis.call('LPOP', KEYS[2])
+	if entry then
+		local waiting = cjson.decode(entry)
+		redis.call('LPUSH', waiting.list, waiting.task)
+	end
+end
+return 1
+`
+
+// concurrencyRenewScript sets the ttl (ARGV[2]) of a lease (KEYS[1]), if it is held by the token in ARGV[1].
+const concurrencyRenewScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`
+
+// concurrencySweepScript moves the next waiting task from a waiting-list (KEYS[2]) back to its list,
+// when the lease (KEYS[3]) expired, for instance because the instance holding it died.
+// Empty waiting-lists are removed from the set of all waiting-lists (KEYS[1]).
+// It returns 1 when a task was moved.
+const concurrencySweepScript = `
+local n = 0
+if redis.call('EXISTS', KEYS[3]) == 0 then
+	local entry = redis.call('LPOP', KEYS[2])
+	if entry then
+		local waiting = cjson.decode(entry)
+		redis.call('LPUSH', waiting.list, waiting.task)
+		n = 1
+	end
+end
+
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	redis.call('SREM', KEYS[1], KEYS[2])
+end
+return n
+`
+
+// A waitingTask is an entry of a waiting-list.
+type waitingTask struct {
+	List string `json:"list"` // the list the task was fetched from
+	Task string `json:"task"` // the raw value of the task
+}
+
+var (
+	concurrencyLeases     = make(map[string]string) // concurrency keys of the leases held by this instance, by their token
+	concurrencyLeasesLock sync.Mutex
+)
+
+func concurrencyKey(key string) string {
+	return conf.RedisQueueKey + ":concurrency:" + key
+}
+
+func concurrencyWaitingKey(key string) string {
+	return concurrencyWaitingSetKey() + ":" + key
+}
+
+func concurrencyWaitingSetKey() string {
+	return conf.RedisQueueKey + ":waiting"
+}
+
+// concurrencyKeyOfWaitingList returns the key of the lease, which the tasks in the passed waiting-list wait for.
+func concurrencyKeyOfWaitingList(waitingKey string) string {
+	return concurrencyKey(strings.TrimPrefix(waitingKey, concurrencyWaitingKey("")))
+}
+
+// acquireConcurrencyLease acquires the lease on the concurrency key of a fetched task, whose token
+// is stored in the processingTask. It returns false, when another task holds the lease and the task
+// was moved to the waiting-list. The lease expires when it is not renewed within the instance_timeout.
+func acquireConcurrencyLease(pt *processingTask, task QueueTask) (bool, error) {
+	if task.ConcurrencyKey == "" {
+		return true, nil
+	}
+
+	entry, err := json.Marshal(waitingTask{List: pt.queueKey, Task: pt.value})
+	if err != nil {
+		return false, err
+	}
+
+	token := createToken()
+	acquired, err := redisPoolLua(3, concurrencyAcquireScript, 4,
+		concurrencyKey(task.ConcurrencyKey),
+		processingKey(pt.queueKey, instanceID),
+		concurrencyWaitingKey(task.ConcurrencyKey),
+		concurrencyWaitingSetKey(),
+		token, conf.InstanceTimeout, pt.value, string(entry)).Int()
+	if err != nil || acquired == 0 {
+		return false, err
+	}
+
+	pt.lease = token
+	concurrencyLeasesLock.Lock()
+	concurrencyLeases[token] = task.ConcurrencyKey
+	concurrencyLeasesLock.Unlock()
+	return true, nil
+}
+
+// releaseConcurrencyLease releases the lease on the concurrency key of a task, after its execution
+// finished, so the next waiting task can be executed. A lease that expired in the meantime and was
+// acquired by another task is kept.
+func releaseConcurrencyLease(task QueueTask, token string) {
+	if token == "" {
+		return
+	}
+
+	concurrencyLeasesLock.Lock()
+	delete(concurrencyLeases, token)
+	concurrencyLeasesLock.Unlock()
+
+	reply := redisPoolLua(3, concurrencyReleaseScript, 2, concurrencyKey(task.ConcurrencyKey), concurrencyWaitingKey(task.ConcurrencyKey), token)
+	if reply.Err != nil {
+		output.NotifyError("releaseConcurrencyLease(), concurrencyReleaseScript:", reply.Err)
+	}
+}
+
+// renewConcurrencyLeases extends all leases held by this instance, together with the heartbeat.
+func renewConcurrencyLeases() {
+	leases := make(map[string]string)
+	concurrencyLeasesLock.Lock()
+	for token, key := range concurrencyLeases {
+		leases[token] = key
+	}
+	concurrencyLeasesLock.Unlock()
+
+	for token, key := range leases {
+		reply := redisPoolLua(3, concurrencyRenewScript, 1, concurrencyKey(key), token, conf.InstanceTimeout)
+		if reply.Err != nil {
+			output.NotifyError("renewConcurrencyLeases(), concurrencyRenewScript:", reply.Err)
+		}
+	}
+}
+
+// sweepConcurrencyLeases moves the next waiting task of every key back to its list, when the lease expired.
+// The other waiting tasks keep waiting, until this task finished and released its own lease.
+func sweepConcurrencyLeases() {
+	waitingKeys, err := redisPoolCmd(1, "SMEMBERS", concurrencyWaitingSetKey()).List()
+	if err != nil {
+		output.NotifyError("sweepConcurrencyLeases(), SMEMBERS:", err)
+		return
+	}
+
+	n := 0
+	for _, waitingKey := range waitingKeys {
+		moved, err := redisPoolLua(1, concurrencySweepScript, 3, concurrencyWaitingSetKey(), waitingKey, concurrencyKeyOfWaitingList(waitingKey)).Int()
+		if err != nil {
+			output.NotifyError("sweepConcurrencyLeases(), concurrencySweepScript:", err)
+			return
+		}
+		n += moved
+	}
+
+	if n > 0 {
+		output.Debug("Re-queued", n, "tasks waiting for expired concurrency leases")
+	}
+}
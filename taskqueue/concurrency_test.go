@@ -0,0 +1,132 @@
+package taskqueue
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConcurrencyKeyOfWaitingList(t *testing.T) {
+	previousKey := conf.RedisQueueKey
+	defer func() { conf.RedisQueueKey = previousKey }()
+	conf.RedisQueueKey = "test"
+
+	keys := map[string]string{
+		"account-1234":      "test:concurrency:account-1234",
+		"a:waiting:b":       "test:concurrency:a:waiting:b",
+		"test:waiting:1234": "test:concurrency:test:waiting:1234",
+		"":                  "test:concurrency:",
+	}
+	for key, expected := range keys {
+		waitingKey := concurrencyWaitingKey(key)
+		if lease := concurrencyKeyOfWaitingList(waitingKey); lease != expected || lease != concurrencyKey(key) {
+			t.Log("concurrencyKeyOfWaitingList() should return the lease of the waiting-list")
+			t.Log("waitingKey:", waitingKey, "expected:", expected, "returned:", lease)
+			t.Fail()
+		}
+	}
+}
+
+func TestConcurrencyLease(t *testing.T) {
+	defer testRedis(t)()
+
+	queueKey := conf.RedisQueueKey + ":type"
+	first := QueueTask{Args: []string{"1"}, ConcurrencyKey: "account"}
+	second := QueueTask{Args: []string{"2"}, ConcurrencyKey: "account"}
+
+	fetch := func(task QueueTask) processingTask {
+		value, _ := task.GetJSONString()
+		redisPool.Cmd("RPUSH", processingKey(queueKey, instanceID), value)
+		return processingTask{queueKey: queueKey, value: value}
+	}
+
+	firstPt := fetch(first)
+	if acquired, err := acquireConcurrencyLease(&firstPt, first); !acquired || err != nil {
+		t.Log("acquireConcurrencyLease() should acquire a free lease")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+	if firstPt.lease == "" || concurrencyLeases[firstPt.lease] != "account" {
+		t.Log("acquireConcurrencyLease() should remember the token of the lease")
+		t.Fail()
+	}
+
+	secondPt := fetch(second)
+	if acquired, err := acquireConcurrencyLease(&secondPt, second); acquired || err != nil || secondPt.lease != "" {
+		t.Log("acquireConcurrencyLease() should not acquire a lease held by another task")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	processing, _ := redisPool.Cmd("LRANGE", processingKey(queueKey, instanceID), 0, -1).List()
+	if !reflect.DeepEqual(processing, []string{firstPt.value}) {
+		t.Log("acquireConcurrencyLease() should move the waiting task out of the processing-list")
+		t.Log("processing: ", processing)
+		t.Fail()
+	}
+
+	// an expired lease releases the next waiting task, even without the instance holding it
+	redisPool.Cmd("DEL", concurrencyKey("account"))
+	sweepConcurrencyLeases()
+	values, _ := redisPool.Cmd("LRANGE", queueKey, 0, -1).List()
+	if !reflect.DeepEqual(values, []string{secondPt.value}) {
+		t.Log("sweepConcurrencyLeases() should move the waiting task back to its list")
+		t.Log("values: ", values)
+		t.Fail()
+	}
+	if n, _ := redisPool.Cmd("SCARD", concurrencyWaitingSetKey()).Int(); n != 0 {
+		t.Log("sweepConcurrencyLeases() should remove empty waiting-lists from the set")
+		t.Fail()
+	}
+
+	// the released task does not release the lease the second task acquired in the meantime
+	redisPool.Cmd("LPOP", queueKey)
+	secondPt = fetch(second)
+	if acquired, err := acquireConcurrencyLease(&secondPt, second); !acquired || err != nil {
+		t.Log("acquireConcurrencyLease() should acquire an expired lease")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	releaseConcurrencyLease(first, firstPt.lease)
+	if _, ok := concurrencyLeases[firstPt.lease]; ok {
+		t.Log("releaseConcurrencyLease() should forget the token of the lease")
+		t.Fail()
+	}
+	token, _ := redisPool.Cmd("GET", concurrencyKey("account")).Str()
+	if token != secondPt.lease || concurrencyLeases[secondPt.lease] != "account" {
+		t.Log("releaseConcurrencyLease() should keep the lease acquired by another task")
+		t.Log("token: ", token)
+		t.Fail()
+	}
+
+	releaseConcurrencyLease(second, secondPt.lease)
+}
+
+func TestConcurrencyRelease(t *testing.T) {
+	defer testRedis(t)()
+
+	queueKey := conf.RedisQueueKey + ":type"
+	task := QueueTask{ConcurrencyKey: "account"}
+	value, _ := task.GetJSONString()
+
+	pt := processingTask{queueKey: queueKey, value: value}
+	redisPool.Cmd("RPUSH", processingKey(queueKey, instanceID), value)
+	acquireConcurrencyLease(&pt, task)
+
+	waiting := processingTask{queueKey: queueKey, value: "waiting"}
+	redisPool.Cmd("RPUSH", processingKey(queueKey, instanceID), "waiting")
+	acquireConcurrencyLease(&waiting, task)
+
+	releaseConcurrencyLease(task, pt.lease)
+	if n, _ := redisPool.Cmd("EXISTS", concurrencyKey("account")).Int(); n != 0 {
+		t.Log("releaseConcurrencyLease() should delete the lease")
+		t.Fail()
+	}
+
+	values, _ := redisPool.Cmd("LRANGE", queueKey, 0, -1).List()
+	if !reflect.DeepEqual(values, []string{"waiting"}) {
+		t.Log("releaseConcurrencyLease() should push the next waiting task back to its list")
+		t.Log("values: ", values)
+		t.Fail()
+	}
+}
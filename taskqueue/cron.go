@@ -0,0 +1,169 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for tasks that Gordon enqueues itself on a schedule.
+package taskqueue
+
+import (
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/cron"
+	"github.com/nevsnode/gordon/output"
+	"strconv"
+	"time"
+)
+
+const (
+	cronGracePeriod = time.Minute // time after which a tick is considered to be missed
+	cronMaxMissed   = 100         // maximum number of tasks enqueued for missed ticks
+)
+
+// cronScript enqueues the tasks for a tick, when no other instance did so already.
+// KEYS[1] stores the timestamp of the last tick and KEYS[2] is the list of the task.
+// ARGV[1] is the expected timestamp of the last tick, ARGV[2] the timestamp of the new tick
+// and the remaining arguments are the values pushed to the list.
+const cronScript = `
+local last = redis.call('GET', KEYS[1]) or '0'
+if last ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+if #ARGV > 2 then
+	redis.call('RPUSH', KEYS[2], unpack(ARGV, 3))
+end
+return 1
+`
+
+type cronEntry struct {
+	config.Cron
+	schedule cron.Schedule
+	location *time.Location
+	next     time.Time
+}
+
+func cronKey(name string) string {
+	return conf.RedisQueueKey + ":cron:" + name
+}
+
+func cronWorker() {
+	if len(conf.Cron) == 0 {
+		return
+	}
+
+	now := time.Now()
+	entries := make([]*cronEntry, 0, len(conf.Cron))
+	for _, cr := range conf.Cron {
+		// the values were already validated in the config-package
+		schedule, _ := cron.Parse(cr.Schedule)
+		location, _ := time.LoadLocation(cr.Timezone)
+
+		e := &cronEntry{
+			Cron:     cr,
+			schedule: schedule,
+			location: location,
+		}
+
+		// continue after the last tick, so that missed ticks are handled
+		last, err := getLastCronTick(e)
+		if err != nil {
+			output.NotifyError("cronWorker(), getLastCronTick():", err)
+		}
+		if last > 0 {
+			e.next = e.schedule.Next(time.Unix(last, 0).In(e.location))
+		} else {
+			e.next = e.schedule.Next(now.In(e.location))
+		}
+
+		entries = append(entries, e)
+	}
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for now = range ticker.C {
+		if isShuttingDown() {
+			break
+		}
+
+		for _, e := range entries {
+			if e.next.IsZero() || now.Before(e.next) {
+				continue
+			}
+
+			if err := fireCron(e, now); err != nil {
+				output.NotifyError("fireCron():", err, "\nCron:", e.Name)
+			}
+
+			e.next = e.schedule.Next(now.In(e.location))
+		}
+	}
+
+	output.Debug("Finished cron-worker")
+}
+
+func getLastCronTick(e *cronEntry) (int64, error) {
+	reply := redisPoolCmd(3, "GET", cronKey(e.Name))
+	if reply.IsType(redis.Nil) {
+		return 0, nil
+	}
+	return reply.Int64()
+}
+
+// fireCron enqueues the task of the cron entry for all ticks since the last one,
+// depending on the policy for missed ticks.
+func fireCron(e *cronEntry, now time.Time) error {
+	last, err := getLastCronTick(e)
+	if err != nil {
+		return err
+	}
+
+	from := e.next.Add(-time.Second)
+	if last > 0 {
+		from = time.Unix(last, 0)
+	}
+
+	var latest time.Time
+	ticks := 0
+	for t := e.schedule.Next(from.In(e.location)); !t.IsZero() && !t.After(now); t = e.schedule.Next(t) {
+		latest = t
+		ticks++
+	}
+
+	// another instance already handled this tick
+	if ticks == 0 {
+		return nil
+	}
+
+	count := 0
+	switch e.Missed {
+	case config.CronMissedSkip:
+		if now.Sub(latest) < cronGracePeriod {
+			count = 1
+		}
+	case config.CronMissedOnce:
+		count = 1
+	case config.CronMissedAll:
+		count = ticks
+		if count > cronMaxMissed {
+			count = cronMaxMissed
+		}
+	}
+
+	value, err := QueueTask{Args: e.Args, Env: e.Env, EnqueuedAt: now.Unix()}.GetJSONString()
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, count)
+	for i := range values {
+		values[i] = value
+	}
+
+	fired, err := redisPoolLua(3, cronScript, 2, cronKey(e.Name), taskQueueKey(conf.Tasks[e.Task], ""), strconv.FormatInt(last, 10), latest.Unix(), values).Int()
+	if err != nil {
+		return err
+	}
+
+	if fired == 1 {
+		output.Debug("Enqueued", count, "tasks for cron", e.Name, "with", ticks, "ticks since the last run")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for expired tasks, which are dropped instead of being executed.
+package taskqueue
+
+import (
+	"fmt"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"github.com/nevsnode/gordon/stats"
+	"time"
+)
+
+var errorTaskExpired = fmt.Errorf("Task expired")
+
+func expiredKey(ct config.Task) string {
+	return conf.RedisQueueKey + ":" + ct.Type + ":expired"
+}
+
+// expireTask drops a task that expired before it was executed. Depending on the expired_mode,
+// it is removed from its processing-list or additionally stored in the expired-list of its type.
+func expireTask(pt processingTask, task QueueTask, ct config.Task) {
+	stats.ExpiredTask(ct.Type)
+
+	task.ErrorMessage = errorTaskExpired.Error()
+	setTaskStatus(task, ct, statusDiscarded, map[string]interface{}{
+		"finished_at": time.Now().Unix(),
+		"error":       task.ErrorMessage,
+	})
+	storeTaskResult(task, ct, statusDiscarded, nil, -1)
+	finishWorkflowStep(task, statusDiscarded)
+	releaseUniqueTask(task, ct)
+
+	if ct.ExpiredMode == config.ExpiredModeList {
+		storeExpiredTask(task, ct)
+	}
+	finishTask(pt)
+}
+
+// storeExpiredTask adds an expired task to the expired-list of its type
+// and removes the entries that exceeded their time-to-live.
+func storeExpiredTask(task QueueTask, ct config.Task) {
+	now := time.Now().Unix()
+	task.ExpiredAt = now
+
+	jsonString, err := task.GetJSONString()
+	if err != nil {
+		output.NotifyError("storeExpiredTask(), task.GetJSONString():", err)
+		return
+	}
+
+	reply := redisPoolLua(3, failedScript, 1, expiredKey(ct), jsonString, now-int64(ct.ExpiredTasksTTL), ct.ExpiredTasksMax, ct.ExpiredTasksTTL, now, "expired_at")
+	if reply.Err != nil {
+		output.NotifyError("storeExpiredTask(), failedScript:", reply.Err, "\nPayload:\n", jsonString)
+	}
+}
+
+// trimExpiredTasks removes the entries that exceeded their time-to-live from the
+// expired-list of the given type, even when no new tasks expired.
+func trimExpiredTasks(ct config.Task) {
+	if ct.ExpiredMode != config.ExpiredModeList {
+		return
+	}
+
+	now := time.Now().Unix()
+	reply := redisPoolLua(1, failedScript, 1, expiredKey(ct), "", now-int64(ct.ExpiredTasksTTL), ct.ExpiredTasksMax, ct.ExpiredTasksTTL, now, "expired_at")
+	if reply.Err != nil {
+		output.NotifyError("trimExpiredTasks(), failedScript:", reply.Err)
+	}
+}
@@ -0,0 +1,40 @@
+package taskqueue
+
+import (
+	"fmt"
+	"github.com/nevsnode/gordon/config"
+	"testing"
+	"time"
+)
+
+func TestTrimExpiredTasks(t *testing.T) {
+	defer testRedis(t)()
+
+	ct := config.Task{Type: "type", ExpiredMode: config.ExpiredModeList, ExpiredTasksTTL: 60, ExpiredTasksMax: 2}
+	now := time.Now().Unix()
+	redisPool.Cmd("RPUSH", expiredKey(ct),
+		fmt.Sprintf(`{"args":["expired"],"expired_at":%d}`, now-120),
+		fmt.Sprintf(`{"args":["current"],"expired_at":%d}`, now))
+
+	storeExpiredTask(QueueTask{Args: []string{"new"}}, ct)
+
+	values, _ := redisPool.Cmd("LRANGE", expiredKey(ct), 0, -1).List()
+	if len(values) != 2 {
+		t.Log("storeExpiredTask() should remove the entries exceeding their time-to-live")
+		t.Log("values: ", values)
+		t.FailNow()
+	}
+
+	task, err := NewQueueTask(values[1])
+	if err != nil || task.Args[0] != "new" || task.ExpiredAt < now {
+		t.Log("storeExpiredTask() should add the task with the time it expired")
+		t.Log("value: ", values[1], "err: ", err)
+		t.Fail()
+	}
+
+	if ttl, _ := redisPool.Cmd("TTL", expiredKey(ct)).Int(); ttl < 1 || ttl > 60 {
+		t.Log("storeExpiredTask() should set the ttl of the expired-list")
+		t.Log("ttl: ", ttl)
+		t.Fail()
+	}
+}
@@ -0,0 +1,81 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for follow-up tasks, which scripts write to a side-channel
+// and which are enqueued after the script succeeded.
+package taskqueue
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nevsnode/gordon/config"
+)
+
+const (
+	followUpsEnv = "GORDON_TASKS_FD" // environment variable containing the file descriptor for follow-up tasks
+	followUpsMax = 10000             // maximum number of follow-up tasks per execution
+)
+
+// followUpScript removes a finished task (ARGV[1]) from a processing-list (KEYS[1]) and
+// pushes its follow-up tasks, which are passed as pairs of a list and a task in the remaining arguments.
+// The follow-up tasks are only pushed, when the finished task was still in the processing-list.
+const followUpScript = `
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+
+for i = 2, #ARGV, 2 do
+	redis.call('RPUSH', ARGV[i], ARGV[i + 1])
+end
+return 1
+`
+
+// A followUpTask is a record written by a script to the side-channel. Besides the type
+// of the task, it contains the properties of the task itself.
+type followUpTask struct {
+	QueueTask
+	Type string `json:"type"` // type of the task
+}
+
+// parseFollowUps parses the json-records of the follow-up tasks, and returns them as pairs
+// of the list they are pushed to and the task. All tasks need to have a configured type.
+func parseFollowUps(records []string, tasks map[string]config.Task, now int64) ([]interface{}, error) {
+	pairs := make([]interface{}, 0, len(records)*2)
+	for i, record := range records {
+		var ft followUpTask
+		if err := json.Unmarshal([]byte(record), &ft); err != nil {
+			return nil, fmt.Errorf("Invalid follow-up task %d: %s", i+1, err)
+		}
+
+		ct, ok := tasks[ft.Type]
+		if !ok {
+			return nil, fmt.Errorf("Invalid follow-up task %d: unknown type %q", i+1, ft.Type)
+		}
+
+		if ft.EnqueuedAt == 0 {
+			ft.EnqueuedAt = now
+		}
+
+		value, err := ft.QueueTask.GetJSONString()
+		if err != nil {
+			return nil, err
+		}
+
+		priority := ""
+		if ct.HasPriority(ft.Priority) {
+			priority = ft.Priority
+		}
+		pairs = append(pairs, taskQueueKey(ct, priority), value)
+	}
+	return pairs, nil
+}
+
+// finishTaskWithFollowUps removes a task from the processing-list after it succeeded,
+// and atomically enqueues its follow-up tasks.
+func finishTaskWithFollowUps(pt processingTask, followUps []interface{}) error {
+	if len(followUps) == 0 {
+		finishTask(pt)
+		return nil
+	}
+
+	reply := redisPoolLua(3, followUpScript, 1, processingKey(pt.queueKey, instanceID), pt.value, followUps)
+	return reply.Err
+}
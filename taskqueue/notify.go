@@ -0,0 +1,132 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for waking up the queue-worker, as soon as new tasks
+// were pushed to one of the lists, using the keyspace notifications of Redis.
+package taskqueue
+
+import (
+	"github.com/jpillora/backoff"
+	"github.com/mediocregopher/radix.v2/pubsub"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	wakeupChan chan bool
+	notifyKeys map[string]bool
+)
+
+// notifyEvents contains the keyspace events which indicate that a new task was pushed.
+var notifyEvents = map[string]bool{
+	"lpush":   true,
+	"rpush":   true,
+	"linsert": true,
+}
+
+// wakeup causes the queue-worker to check for new tasks immediately,
+// instead of waiting for the end of its current interval.
+func wakeup() {
+	if conf.FetchMode != config.FetchModeNotify {
+		return
+	}
+
+	select {
+	case wakeupChan <- true:
+	default:
+	}
+}
+
+// addNotifyKey registers a key, which when receiving new tasks, shall wake up the queue-worker.
+func addNotifyKey(key string) {
+	notifyKeys[key] = true
+}
+
+func notifyWorker() {
+	connBackoff := backoff.Backoff{
+		Min:    time.Duration(250) * time.Millisecond,
+		Max:    time.Duration(conf.IntervalMax) * time.Millisecond,
+		Factor: math.E,
+		Jitter: true,
+	}
+
+	checkKeyspaceEvents()
+
+	for !isShuttingDown() {
+		err := receiveNotifications(&connBackoff)
+		if isShuttingDown() {
+			break
+		}
+
+		output.NotifyError("notifyWorker(), receiveNotifications():", err)
+		time.Sleep(connBackoff.Duration())
+
+		// there might have been new tasks while we were not subscribed
+		wakeup()
+	}
+}
+
+// receiveNotifications subscribes to the keyspace events of all relevant keys
+// and triggers a wakeup for every received push-event.
+// It only returns on errors or when Gordon is shutting down.
+func receiveNotifications(connBackoff *backoff.Backoff) error {
+	client, err := redisDialFunction(conf.RedisNetwork, conf.RedisAddress)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sub := pubsub.NewSubClient(client)
+	sr := sub.PSubscribe("__keyspace@*__:" + conf.RedisQueueKey + ":*")
+	if sr.Err != nil {
+		return sr.Err
+	}
+
+	output.Debug("Subscribed to keyspace notifications")
+	connBackoff.Reset()
+
+	for {
+		sr = sub.Receive()
+		if isShuttingDown() {
+			return nil
+		}
+
+		// timeouts occur regularly, when no messages were received
+		if sr.Timeout() {
+			continue
+		}
+		if sr.Err != nil {
+			return sr.Err
+		}
+		if sr.Type != pubsub.Message || !notifyEvents[sr.Message] {
+			continue
+		}
+
+		key := sr.Channel[strings.Index(sr.Channel, "__:")+3:]
+		if notifyKeys[key] {
+			wakeup()
+		}
+	}
+}
+
+// checkKeyspaceEvents notifies, when the Redis server is not configured to send
+// the keyspace notifications for lists.
+func checkKeyspaceEvents() {
+	reply := redisPoolCmd(1, "CONFIG", "GET", "notify-keyspace-events")
+	if reply.Err != nil {
+		// the CONFIG command might not be available on managed servers
+		output.Debug("checkKeyspaceEvents(), CONFIG GET:", reply.Err)
+		return
+	}
+
+	values, err := reply.List()
+	if err != nil || len(values) != 2 {
+		return
+	}
+
+	flags := values[1]
+	if !strings.Contains(flags, "K") || (!strings.Contains(flags, "l") && !strings.Contains(flags, "A")) {
+		output.NotifyError("Keyspace notifications for lists are not enabled on the Redis server (notify-keyspace-events is \"" + flags + "\").\nNew tasks will only be fetched in the interval of interval_max.")
+	}
+}
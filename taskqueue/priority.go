@@ -0,0 +1,85 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for tasks with multiple priorities, which are stored
+// in separate lists per priority.
+package taskqueue
+
+import (
+	"github.com/nevsnode/gordon/config"
+)
+
+// priorityPickers stores the state of the weighted mode per task type.
+// It is only used by the queue-worker, so it needs no locking.
+var priorityPickers = make(map[string]*weightedRoundRobin)
+
+// taskQueueKey returns the name of the list for the given priority of a task.
+// The list of the default priority is named without suffix.
+func taskQueueKey(ct config.Task, priority string) string {
+	queueKey := conf.RedisQueueKey + ":" + ct.Type
+	if priority == "" || priority == ct.DefaultPriority {
+		return queueKey
+	}
+	return queueKey + ":" + priority
+}
+
+// taskQueueKeys returns the names of all lists of a task, ordered from highest to lowest priority.
+func taskQueueKeys(ct config.Task) []string {
+	keys := make([]string, len(ct.Priorities))
+	for i, priority := range ct.Priorities {
+		keys[i] = taskQueueKey(ct, priority)
+	}
+	return keys
+}
+
+// fetchOrder returns the lists of a task in the order they shall be checked for the next task.
+// In the strict mode this is always the order of their priorities. In the weighted mode the
+// next picked list comes first, followed by the others in the order of their priorities.
+func fetchOrder(ct config.Task) []string {
+	keys := taskQueueKeys(ct)
+	if ct.PriorityMode != config.PriorityModeWeighted || len(keys) < 2 {
+		return keys
+	}
+
+	picker := priorityPickers[ct.Type]
+	if picker == nil {
+		picker = newWeightedRoundRobin(ct.PriorityWeights)
+		priorityPickers[ct.Type] = picker
+	}
+
+	first := picker.next()
+	order := []string{keys[first]}
+	order = append(order, keys[:first]...)
+	return append(order, keys[first+1:]...)
+}
+
+// A weightedRoundRobin distributes picks between entries according to their weights,
+// interleaving them as smoothly as possible.
+type weightedRoundRobin struct {
+	weights []int
+	current []int
+	total   int
+}
+
+func newWeightedRoundRobin(weights []int) *weightedRoundRobin {
+	w := &weightedRoundRobin{
+		weights: weights,
+		current: make([]int, len(weights)),
+	}
+	for _, weight := range weights {
+		w.total += weight
+	}
+	return w
+}
+
+// next returns the index of the next picked entry.
+func (w *weightedRoundRobin) next() int {
+	best := 0
+	for i, weight := range w.weights {
+		w.current[i] += weight
+		if w.current[i] > w.current[best] {
+			best = i
+		}
+	}
+
+	w.current[best] -= w.total
+	return best
+}
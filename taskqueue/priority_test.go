@@ -0,0 +1,65 @@
+package taskqueue
+
+import (
+	"github.com/nevsnode/gordon/config"
+	"reflect"
+	"testing"
+)
+
+func TestWeightedRoundRobin(t *testing.T) {
+	w := newWeightedRoundRobin([]int{4, 2, 1})
+
+	picks := make([]int, 3)
+	for i := 0; i < 70; i++ {
+		picks[w.next()]++
+	}
+
+	if !reflect.DeepEqual(picks, []int{40, 20, 10}) {
+		t.Log("weightedRoundRobin.next() should pick the entries according to their weights")
+		t.Log("picks:", picks)
+		t.Fail()
+	}
+
+	w = newWeightedRoundRobin([]int{1, 1})
+	if w.next() == w.next() {
+		t.Log("weightedRoundRobin.next() should alternate between entries with the same weight")
+		t.Fail()
+	}
+}
+
+func TestFetchOrder(t *testing.T) {
+	conf.RedisQueueKey = "test"
+	ct := config.Task{
+		Type:            "something",
+		Priorities:      []string{"high", "normal", "low"},
+		DefaultPriority: "normal",
+		PriorityMode:    config.PriorityModeStrict,
+		PriorityWeights: []int{1, 1, 1},
+	}
+
+	strict := []string{"test:something:high", "test:something", "test:something:low"}
+	if !reflect.DeepEqual(taskQueueKeys(ct), strict) {
+		t.Log("taskQueueKeys() should return the lists ordered by priority")
+		t.Log("keys:", taskQueueKeys(ct))
+		t.Fail()
+	}
+	if !reflect.DeepEqual(fetchOrder(ct), strict) {
+		t.Log("fetchOrder() should return the lists ordered by priority in the strict mode")
+		t.Fail()
+	}
+
+	ct.PriorityMode = config.PriorityModeWeighted
+	expected := [][]string{
+		strict,
+		{"test:something", "test:something:high", "test:something:low"},
+		{"test:something:low", "test:something:high", "test:something"},
+	}
+	for _, e := range expected {
+		if order := fetchOrder(ct); !reflect.DeepEqual(order, e) {
+			t.Log("fetchOrder() should put the picked list first in the weighted mode")
+			t.Log("expected:", e)
+			t.Log("returned:", order)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for the processes of running tasks, which can be terminated
+// together with all their child processes.
+package taskqueue
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	errorTaskInterrupted = fmt.Errorf("Task was interrupted by shutdown")
+
+	processes           = make(map[*process]bool)
+	processesTerminated = false
+	processesLock       sync.Mutex
+)
+
+// A process is the running script/application of a task. As it is started in its own
+// process group, signals are sent to the whole group, so child processes receive them too.
+type process struct {
+	cmd    *exec.Cmd
+	grace  time.Duration
+	done   chan bool
+	once   sync.Once
+	mutex  sync.Mutex
+	reason error
+}
+
+// startProcess starts the passed command and returns the corresponding process.
+// When terminated, the process gets the passed grace period before it is killed.
+func startProcess(cmd *exec.Cmd, grace time.Duration) (*process, error) {
+	processesLock.Lock()
+	defer processesLock.Unlock()
+
+	if processesTerminated {
+		return nil, errorTaskInterrupted
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &process{
+		cmd:   cmd,
+		grace: grace,
+		done:  make(chan bool),
+	}
+	processes[p] = true
+	return p, nil
+}
+
+// terminateProcesses terminates all running processes with the passed reason,
+// and prevents new processes from being started.
+func terminateProcesses(reason error) {
+	processesLock.Lock()
+	defer processesLock.Unlock()
+
+	processesTerminated = true
+	for p := range processes {
+		p.terminate(reason)
+	}
+}
+
+// wait waits for the process to exit. If the process was terminated, the reason
+// passed to terminate is returned instead of the error of the command.
+func (p *process) wait() error {
+	err := p.cmd.Wait()
+	close(p.done)
+
+	processesLock.Lock()
+	delete(processes, p)
+	processesLock.Unlock()
+
+	if reason := p.terminatedReason(); reason != nil {
+		return reason
+	}
+	return err
+}
+
+// terminate sends SIGTERM to the process group, and SIGKILL when the process
+// did not exit after its grace period. Only the first call has an effect.
+func (p *process) terminate(reason error) {
+	p.once.Do(func() {
+		// the process group does not exist anymore
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		p.mutex.Lock()
+		p.reason = reason
+		p.mutex.Unlock()
+
+		pgid := -p.cmd.Process.Pid
+		syscall.Kill(pgid, syscall.SIGTERM)
+
+		go func() {
+			select {
+			case <-p.done:
+			case <-time.After(p.grace):
+				syscall.Kill(pgid, syscall.SIGKILL)
+			}
+		}()
+	})
+}
+
+func (p *process) terminatedReason() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.reason
+}
@@ -0,0 +1,186 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for the progress of running tasks, which scripts report
+// together with heartbeats through a side-channel.
+package taskqueue
+
+import (
+	"fmt"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"github.com/nevsnode/gordon/stats"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	progressEnv      = "GORDON_PROGRESS_FD" // environment variable containing the file descriptor for progress reports
+	progressInterval = time.Second          // interval for storing the progress and checking for stalled tasks
+)
+
+// progressScript stores the progress fields (ARGV) in the status hash of a task (KEYS[1]),
+// unless the hash expired already.
+const progressScript = `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HSET', KEYS[1], unpack(ARGV))
+end
+return 1
+`
+
+// A taskProgress is the progress of a running task, as it was reported by its script.
+type taskProgress struct {
+	task    QueueTask
+	ct      config.Task
+	txn     stats.Transaction
+	mutex   sync.Mutex
+	current stats.Progress
+	changed bool // true if the progress was not stored yet
+}
+
+var (
+	runningProgress     = make(map[*taskProgress]bool) // progress of the tasks being executed by this instance
+	runningProgressLock sync.Mutex
+)
+
+// newTaskProgress starts tracking the progress of a task, whose execution just started.
+func newTaskProgress(task QueueTask, ct config.Task, txn stats.Transaction, started time.Time) *taskProgress {
+	p := &taskProgress{
+		task:    task,
+		ct:      ct,
+		txn:     txn,
+		current: stats.Progress{HeartbeatAt: started.Unix()},
+	}
+
+	runningProgressLock.Lock()
+	runningProgress[p] = true
+	runningProgressLock.Unlock()
+	return p
+}
+
+// finish stops tracking the progress of a task, after its execution finished.
+func (p *taskProgress) finish() {
+	runningProgressLock.Lock()
+	delete(runningProgress, p)
+	runningProgressLock.Unlock()
+}
+
+// handle processes a line the script wrote to the progress side-channel.
+func (p *taskProgress) handle(line []byte) {
+	if err := p.update(string(line), time.Now()); err != nil {
+		output.Debug("Invalid progress of task type", p.ct.Type, "-", err)
+	}
+}
+
+// update applies a line of the progress protocol. Every line counts as heartbeat,
+// and may additionally set the percentage or the message of the progress:
+//
+//	heartbeat
+//	progress <percentage between 0 and 100>
+//	status <message>
+func (p *taskProgress) update(line string, now time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.current.HeartbeatAt = now.Unix()
+	p.current.Stalled = false
+	p.changed = true
+
+	line = strings.TrimSpace(line)
+	command, value := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		command, value = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch command {
+	case "", "heartbeat":
+	case "progress":
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("invalid percentage %q", value)
+		}
+		p.current.Percent = percent
+	case "status":
+		p.current.Message = value
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	return nil
+}
+
+// check returns the progress when it changed since the last check, and whether the task
+// just stalled, as it did not send a heartbeat within the stall_timeout.
+func (p *taskProgress) check(now time.Time) (progress stats.Progress, changed bool, stalled bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	timeout := int64(p.ct.StallTimeout)
+	if timeout > 0 && !p.current.Stalled && now.Unix()-p.current.HeartbeatAt >= timeout {
+		p.current.Stalled = true
+		p.changed = true
+		stalled = true
+	}
+
+	changed = p.changed
+	p.changed = false
+	return p.current, changed, stalled
+}
+
+// progressWorker periodically stores the progress of the running tasks,
+// and reports tasks that stopped sending heartbeats. It keeps running during
+// the shutdown, until all task workers finished.
+func progressWorker() {
+	defer waitGroupTasksDone.Done()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			storeRunningProgress(now)
+		case <-tasksDoneChan:
+			output.Debug("Finished progress-worker")
+			return
+		}
+	}
+}
+
+// storeRunningProgress stores the changed progress of the running tasks.
+func storeRunningProgress(now time.Time) {
+	runningProgressLock.Lock()
+	running := make([]*taskProgress, 0, len(runningProgress))
+	for p := range runningProgress {
+		running = append(running, p)
+	}
+	runningProgressLock.Unlock()
+
+	for _, p := range running {
+		progress, changed, stalled := p.check(now)
+		if stalled {
+			payload, _ := p.task.GetJSONString()
+			output.NotifyError("Task type", p.ct.Type, "stalled, no heartbeat for", p.ct.StallTimeout, "seconds\nPayload:\n", payload)
+		}
+		if changed {
+			p.store(progress)
+		}
+	}
+}
+
+// store passes the progress to the statistics, and stores it in the status hash of the task.
+func (p *taskProgress) store(progress stats.Progress) {
+	p.txn.SetProgress(progress)
+	if !isStatusTracked(p.task, p.ct) {
+		return
+	}
+
+	reply := redisPoolLua(3, progressScript, 1, statusKey(p.task.ID),
+		"progress", strconv.FormatFloat(progress.Percent, 'f', -1, 64),
+		"message", progress.Message,
+		"heartbeat_at", progress.HeartbeatAt,
+		"stalled", strconv.FormatBool(progress.Stalled))
+	if reply.Err != nil {
+		output.NotifyError("taskProgress.store(), progressScript:", reply.Err, "\nTask:", p.task.ID)
+	}
+}
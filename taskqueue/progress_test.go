@@ -0,0 +1,75 @@
+package taskqueue
+
+import (
+	"github.com/nevsnode/gordon/config"
+	"testing"
+	"time"
+)
+
+func TestTaskProgressUpdate(t *testing.T) {
+	started := time.Unix(1000, 0)
+	p := &taskProgress{}
+
+	lines := []string{"progress 12.5", "status Importing rows", "heartbeat", "  progress  80%  ", ""}
+	for i, line := range lines {
+		if err := p.update(line, started.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Log("taskProgress.update() should accept the line", line)
+			t.Log("err: ", err)
+			t.FailNow()
+		}
+	}
+
+	if p.current.Percent != 80 || p.current.Message != "Importing rows" || p.current.HeartbeatAt != 1004 {
+		t.Log("taskProgress.update() should apply the progress, message and heartbeat")
+		t.Log("progress: ", p.current)
+		t.Fail()
+	}
+
+	for _, line := range []string{"progress", "progress 101", "progress -1", "progress half", "done"} {
+		if err := p.update(line, started.Add(10*time.Second)); err == nil {
+			t.Log("taskProgress.update() should return an error for the line", line)
+			t.Fail()
+		}
+	}
+
+	if p.current.Percent != 80 || p.current.HeartbeatAt != 1010 {
+		t.Log("taskProgress.update() should keep the progress on invalid lines, but count them as heartbeat")
+		t.Log("progress: ", p.current)
+		t.Fail()
+	}
+}
+
+func TestTaskProgressStalled(t *testing.T) {
+	started := time.Unix(1000, 0)
+	p := &taskProgress{ct: config.Task{StallTimeout: 10}}
+	p.current.HeartbeatAt = started.Unix()
+
+	if _, changed, stalled := p.check(started.Add(9 * time.Second)); changed || stalled {
+		t.Log("taskProgress.check() should not report a task before the stall_timeout passed")
+		t.Fail()
+	}
+
+	progress, changed, stalled := p.check(started.Add(10 * time.Second))
+	if !changed || !stalled || !progress.Stalled {
+		t.Log("taskProgress.check() should report a task without heartbeat for the stall_timeout")
+		t.Fail()
+	}
+
+	if _, changed, stalled := p.check(started.Add(20 * time.Second)); changed || stalled {
+		t.Log("taskProgress.check() should report a stalled task only once")
+		t.Fail()
+	}
+
+	p.update("heartbeat", started.Add(21*time.Second))
+	progress, changed, stalled = p.check(started.Add(21 * time.Second))
+	if !changed || stalled || progress.Stalled {
+		t.Log("taskProgress.check() should not consider a task stalled after a new heartbeat")
+		t.Fail()
+	}
+
+	p.ct.StallTimeout = 0
+	if _, _, stalled := p.check(started.Add(time.Hour)); stalled {
+		t.Log("taskProgress.check() should not report tasks, when the stall_timeout is 0")
+		t.Fail()
+	}
+}
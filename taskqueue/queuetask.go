@@ -3,23 +3,64 @@
 package taskqueue
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
+	"github.com/nevsnode/gordon/config"
 	"github.com/nevsnode/gordon/utils"
 	"os"
+	"os/exec"
 	"strings"
+	"time"
 )
 
 // A QueueTask is the task as it is enqueued in a Redis-list.
 type QueueTask struct {
-	Args         []string          `json:"args"`                    // list of arguments passed to script/application as argument in the given order
-	Env          map[string]string `json:"env"`                     // map containing environment variables passed to script/application
-	ErrorMessage string            `json:"error_message,omitempty"` // error message that might be created on executing the task
+	ID             string            `json:"id,omitempty"`              // identifier of the task, used for tracking its status
+	Args           []string          `json:"args"`                      // list of arguments passed to script/application as argument in the given order
+	Env            map[string]string `json:"env"`                       // map containing environment variables passed to script/application
+	ErrorMessage   string            `json:"error_message,omitempty"`   // error message that might be created on executing the task
+	EnqueuedAt     int64             `json:"enqueued_at,omitempty"`     // unix timestamp when the task was enqueued, used for measuring its wait time
+	RunAt          int64             `json:"run_at,omitempty"`          // unix timestamp before which the task must not be executed
+	Delay          int64             `json:"delay,omitempty"`           // seconds the execution shall be delayed, relative to when the task is fetched
+	ExpiresAt      int64             `json:"expires_at,omitempty"`      // unix timestamp after which the task is dropped instead of executed
+	TTL            int64             `json:"ttl,omitempty"`             // seconds after which the task expires, relative to when the task was enqueued or fetched
+	Attempts       int               `json:"attempts,omitempty"`        // number of times the execution of the task failed already
+	Priority       string            `json:"priority,omitempty"`        // name of the priority of workflow steps and follow-up tasks, which Gordon pushes to its list
+	Timeout        int               `json:"timeout,omitempty"`         // seconds after which the execution is terminated, overrides the configured timeout
+	FailedAt       int64             `json:"failed_at,omitempty"`       // unix timestamp when the task was stored as failed task
+	ExpiredAt      int64             `json:"expired_at,omitempty"`      // unix timestamp when the task was stored as expired task
+	UniqueKey      string            `json:"unique_key,omitempty"`      // key identifying duplicates of the task, of which only one is executed
+	UniqueLock     string            `json:"unique_lock,omitempty"`     // token of the lock the task holds on its unique key
+	ConcurrencyKey string            `json:"concurrency_key,omitempty"` // key of which only one task is executed at a time
+	Workflow       string            `json:"workflow,omitempty"`        // id of the workflow the task belongs to
+	Step           string            `json:"step,omitempty"`            // name of the step of the workflow
+	Nonce          string            `json:"nonce,omitempty"`           // random token making the entry of the task in a scheduled set unique
 }
 
-// Execute executes the passed script/application with the arguments from the QueueTask object.
-func (q QueueTask) Execute(script string) error {
-	cmd := utils.ExecCommand(script, q.Args...)
+// Execute executes the script/application of the passed task with the arguments from the QueueTask object.
+// When the execution takes longer than the timeout, the process group of the script is terminated.
+func (q QueueTask) Execute(ct config.Task) error {
+	_, err := q.ExecuteWithOutput(ct)
+	return err
+}
+
+// ExecuteWithOutput executes the task like Execute, and additionally returns the output
+// the script/application wrote to stdout when it succeeded.
+func (q QueueTask) ExecuteWithOutput(ct config.Task) (*TaskOutput, error) {
+	return q.execute(ct, executeOptions{})
+}
+
+// executeOptions contains optional hooks into the execution of a task.
+type executeOptions struct {
+	progress func(line []byte) // handler for the lines the script writes to the progress side-channel
+	cancel   <-chan error      // channel receiving the reason, when the running script shall be terminated
+}
+
+// execute executes the task like ExecuteWithOutput, using the passed hooks while the script is running.
+func (q QueueTask) execute(ct config.Task, opts executeOptions) (*TaskOutput, error) {
+	cmd := utils.ExecCommand(ct.Script, q.Args...)
 
 	// add possible environment variables
 	cmd.Env = os.Environ()
@@ -27,13 +68,289 @@ func (q QueueTask) Execute(script string) error {
 		cmd.Env = append(cmd.Env, envKey+"="+envVal)
 	}
 
-	out, err := cmd.Output()
+	stdout := newLimitedBuffer(ct.OutputLimit)
+	stderr := newLimitedBuffer(ct.OutputLimit)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	if len(out) != 0 && err == nil {
-		err = fmt.Errorf("%s", out)
+	// scripts can write follow-up tasks as json-records to an additional file descriptor
+	var followUps []string
+	followUpsChannel, err := newSideChannel(cmd, followUpsEnv, func(line []byte) {
+		if len(bytes.TrimSpace(line)) != 0 {
+			followUps = append(followUps, string(line))
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return err
+	// scripts can report their progress and send heartbeats to another file descriptor
+	var progressChannel *sideChannel
+	if opts.progress != nil {
+		progressChannel, err = newSideChannel(cmd, progressEnv, opts.progress)
+		if err != nil {
+			followUpsChannel.close()
+			return nil, err
+		}
+	}
+
+	p, err := startProcess(cmd, time.Duration(ct.KillTimeout)*time.Second)
+	if err != nil {
+		followUpsChannel.close()
+		if progressChannel != nil {
+			progressChannel.close()
+		}
+		return nil, err
+	}
+	followUpsChannel.started()
+	if progressChannel != nil {
+		progressChannel.started()
+	}
+
+	if timeout := q.GetTimeout(ct); timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			p.terminate(fmt.Errorf("Task timed out after %s", timeout))
+		})
+		defer timer.Stop()
+	}
+
+	if opts.cancel != nil {
+		go func() {
+			select {
+			case reason := <-opts.cancel:
+				p.terminate(reason)
+			case <-p.done:
+			}
+		}()
+	}
+
+	err = p.wait()
+	channelErr := followUpsChannel.close()
+	if progressChannel != nil {
+		// the progress is informational, so lines that could not be read do not fail the task
+		progressChannel.close()
+	}
+	if err == errorTaskInterrupted || err == errorTaskCancelled {
+		return nil, err
+	}
+	if err == nil && channelErr != nil {
+		err = fmt.Errorf("Reading follow-up tasks failed: %s", channelErr)
+	}
+	if err == nil && len(followUps) > followUpsMax {
+		err = fmt.Errorf("Too many follow-up tasks (%d of maximum %d)", len(followUps), followUpsMax)
+	}
+
+	failed := err != nil
+	switch ct.FailureMode {
+	case config.FailureModeExitCode:
+	case config.FailureModeStderr:
+		failed = failed || stderr.Len() != 0
+	default:
+		failed = failed || stdout.Len() != 0
+	}
+
+	if !failed {
+		return &TaskOutput{
+			Stdout:    stdout.buffer.String(),
+			Truncated: stdout.truncated,
+			FollowUps: followUps,
+		}, nil
+	}
+
+	return nil, &ExecError{
+		Err:    err,
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+	}
+}
+
+// A TaskOutput contains the output of a successfully executed task.
+type TaskOutput struct {
+	Stdout    string   // the captured standard output
+	Truncated bool     // true if the output exceeded the output limit and was truncated
+	FollowUps []string // the json-records of the follow-up tasks written by the script
+}
+
+// An ExecError is returned when the execution of a task failed. It contains the
+// output of the script/application.
+type ExecError struct {
+	Err    error  // the error of the execution, nil if the task failed because of its output
+	Stdout string // the captured standard output
+	Stderr string // the captured standard error
+}
+
+func (e *ExecError) Error() string {
+	var msg []string
+	if e.Err != nil {
+		msg = append(msg, e.Err.Error())
+	}
+	if e.Stdout != "" {
+		msg = append(msg, e.Stdout)
+	}
+	if e.Stderr != "" {
+		msg = append(msg, e.Stderr)
+	}
+	return strings.Join(msg, "\n")
+}
+
+// ExitCode returns the exit-code of the script/application, or -1 if it did not exit
+// by itself (e.g. when it was terminated or could not be started).
+func (e *ExecError) ExitCode() int {
+	if exitErr, ok := e.Err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if e.Err == nil {
+		return 0
+	}
+	return -1
+}
+
+// getExitCode returns the exit-code for an error returned by QueueTask.Execute.
+func getExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if execErr, ok := err.(*ExecError); ok {
+		return execErr.ExitCode()
+	}
+	return -1
+}
+
+// A limitedBuffer stores written data up to a limit and silently discards the rest,
+// so a script creating lots of output can not exhaust the memory.
+type limitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func newLimitedBuffer(limit int) *limitedBuffer {
+	return &limitedBuffer{limit: limit}
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if free := b.limit - b.buffer.Len(); b.limit > 0 && n > free {
+		p = p[:free]
+		b.truncated = true
+	}
+
+	b.buffer.Write(p)
+	return n, nil
+}
+
+func (b *limitedBuffer) Len() int {
+	return b.buffer.Len()
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buffer.String() + "\n[output truncated]"
+	}
+	return b.buffer.String()
+}
+
+// GetTimeout returns the duration after which the execution of the QueueTask is terminated.
+// The timeout of the QueueTask takes precedence over the one of the passed task.
+// A duration of 0 means there is no timeout.
+func (q QueueTask) GetTimeout(ct config.Task) time.Duration {
+	if q.Timeout > 0 {
+		return time.Duration(q.Timeout) * time.Second
+	}
+	return time.Duration(ct.Timeout) * time.Second
+}
+
+// GetUniqueKey returns the key identifying duplicates of the QueueTask, or an empty string
+// if the task is not unique. The UniqueKey of the QueueTask takes precedence over the one
+// derived by the unique rule of the passed task.
+func (q QueueTask) GetUniqueKey(ct config.Task) string {
+	if q.UniqueKey != "" {
+		return q.UniqueKey
+	}
+
+	if q.Args == nil {
+		q.Args = make([]string, 0)
+	}
+	if q.Env == nil {
+		q.Env = make(map[string]string)
+	}
+
+	var value interface{}
+	switch ct.Unique {
+	case config.UniqueArgs:
+		value = q.Args
+	case config.UniquePayload:
+		value = []interface{}{q.Args, q.Env}
+	default:
+		return ""
+	}
+
+	// maps are encoded with sorted keys, so the result is always the same
+	b, _ := json.Marshal(value)
+	return fmt.Sprintf("%x", sha1.Sum(b))
+}
+
+// ResolveRunAt converts the relative Delay of the QueueTask to an absolute RunAt timestamp,
+// using the passed timestamp of the current time. An already set RunAt takes precedence.
+// It returns true if the task is due at the passed time.
+func (q *QueueTask) ResolveRunAt(now int64) bool {
+	if q.Delay > 0 {
+		if q.RunAt == 0 {
+			q.RunAt = now + q.Delay
+		}
+		q.Delay = 0
+	}
+
+	return q.RunAt <= now
+}
+
+// ResolveExpiresAt converts the relative TTL of the QueueTask, or otherwise the ttl of the passed task,
+// to an absolute ExpiresAt timestamp. It is relative to EnqueuedAt if set, otherwise to the passed
+// timestamp of the current time. An already set ExpiresAt takes precedence.
+// It returns true if the QueueTask was changed.
+func (q *QueueTask) ResolveExpiresAt(ct config.Task, now int64) bool {
+	if q.EnqueuedAt > 0 {
+		now = q.EnqueuedAt
+	}
+
+	if q.TTL > 0 {
+		if q.ExpiresAt == 0 {
+			q.ExpiresAt = now + q.TTL
+		}
+		q.TTL = 0
+		return true
+	}
+
+	if q.ExpiresAt == 0 && ct.TTL > 0 {
+		q.ExpiresAt = now + int64(ct.TTL)
+		return true
+	}
+	return false
+}
+
+// GetWaitTime returns the duration the QueueTask waited between being enqueued and the passed
+// time it was started, or 0 if it does not contain when it was enqueued. Delayed and retried
+// tasks wait since they became due, so the time before their RunAt is not included.
+func (q QueueTask) GetWaitTime(started time.Time) time.Duration {
+	if q.EnqueuedAt <= 0 {
+		return 0
+	}
+
+	since := q.EnqueuedAt
+	if q.RunAt > since {
+		since = q.RunAt
+	}
+
+	wait := started.Sub(time.Unix(since, 0))
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// IsExpired returns true if the QueueTask expired at the passed timestamp.
+func (q QueueTask) IsExpired(now int64) bool {
+	return q.ExpiresAt > 0 && q.ExpiresAt <= now
 }
 
 // GetJSONString returns the QueueTask object as a json-encoded string
@@ -1,8 +1,11 @@
 package taskqueue
 
 import (
+	"github.com/nevsnode/gordon/config"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestQueueTask(t *testing.T) {
@@ -13,7 +16,7 @@ func TestQueueTask(t *testing.T) {
 
 	qt.Args = make([]string, 1)
 	qt.Args[0] = ""
-	err := qt.Execute(script)
+	err := qt.Execute(config.Task{Script: script})
 	if err != nil {
 		t.Log("QueueTask.Execute() should not return an error")
 		t.Log("err: ", err)
@@ -21,7 +24,7 @@ func TestQueueTask(t *testing.T) {
 	}
 
 	qt.Args[0] = msg
-	err = qt.Execute(script)
+	err = qt.Execute(config.Task{Script: script})
 	if msg != err.Error() {
 		t.Log("Returned error-message should be the same as the first argument")
 		t.Log("err: ", err)
@@ -45,7 +48,7 @@ func TestQueueTask(t *testing.T) {
 	qt2 := QueueTask{
 		Env: map[string]string{"TEST_ENV_VAR": msg},
 	}
-	err = qt2.Execute("../testdata/echoenv.sh")
+	err = qt2.Execute(config.Task{Script: "../testdata/echoenv.sh"})
 	if msg != err.Error() {
 		t.Log("Returned error-message should be the same as the environment variable")
 		t.Log("err: ", err)
@@ -135,3 +138,402 @@ func TestParseQueueTask(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestQueueTaskResolveRunAt(t *testing.T) {
+	var now int64 = 1500000000
+
+	qt := QueueTask{}
+	if !qt.ResolveRunAt(now) {
+		t.Log("QueueTask.ResolveRunAt() should return true for tasks without RunAt and Delay")
+		t.Fail()
+	}
+
+	qt = QueueTask{Delay: 600}
+	if qt.ResolveRunAt(now) {
+		t.Log("QueueTask.ResolveRunAt() should return false for delayed tasks")
+		t.Fail()
+	}
+	if qt.RunAt != now+600 || qt.Delay != 0 {
+		t.Log("QueueTask.ResolveRunAt() should convert Delay to RunAt")
+		t.Log("RunAt:", qt.RunAt, "Delay:", qt.Delay)
+		t.Fail()
+	}
+	if !qt.ResolveRunAt(now + 600) {
+		t.Log("QueueTask.ResolveRunAt() should return true once RunAt is reached")
+		t.Fail()
+	}
+
+	qt = QueueTask{RunAt: now - 10, Delay: 600}
+	if !qt.ResolveRunAt(now) {
+		t.Log("QueueTask.ResolveRunAt() should prefer RunAt over Delay")
+		t.Fail()
+	}
+
+	jsonString, _ := qt.GetJSONString()
+	jsonStringExpected := `{"args":[],"env":{},"run_at":1499999990}`
+	if jsonString != jsonStringExpected {
+		t.Log("QueueTask.GetJSONString() should return the expected string")
+		t.Log("Expected:", jsonStringExpected)
+		t.Log("Returned:", jsonString)
+		t.Fail()
+	}
+}
+
+func TestQueueTaskResolveExpiresAt(t *testing.T) {
+	var now int64 = 1500000000
+	ct := config.Task{TTL: 3600}
+
+	qt := QueueTask{}
+	if qt.ResolveExpiresAt(config.Task{}, now) || qt.IsExpired(now+86400) {
+		t.Log("QueueTask.ResolveExpiresAt() should not set an expiry without any ttl")
+		t.Fail()
+	}
+
+	qt = QueueTask{TTL: 60}
+	if !qt.ResolveExpiresAt(ct, now) || qt.ExpiresAt != now+60 || qt.TTL != 0 {
+		t.Log("QueueTask.ResolveExpiresAt() should convert the TTL to ExpiresAt, preferring it over the ttl of the task")
+		t.Log("ExpiresAt:", qt.ExpiresAt, "TTL:", qt.TTL)
+		t.Fail()
+	}
+	if qt.IsExpired(now+59) || !qt.IsExpired(now+60) {
+		t.Log("QueueTask.IsExpired() should return true once ExpiresAt is reached")
+		t.Fail()
+	}
+
+	qt = QueueTask{}
+	if !qt.ResolveExpiresAt(ct, now) || qt.ExpiresAt != now+3600 {
+		t.Log("QueueTask.ResolveExpiresAt() should use the ttl of the task")
+		t.Log("ExpiresAt:", qt.ExpiresAt)
+		t.Fail()
+	}
+	if qt.ResolveExpiresAt(ct, now+10) || qt.ExpiresAt != now+3600 {
+		t.Log("QueueTask.ResolveExpiresAt() should keep a resolved ExpiresAt")
+		t.Fail()
+	}
+
+	qt = QueueTask{TTL: 60, EnqueuedAt: now - 3600}
+	if !qt.ResolveExpiresAt(ct, now) || !qt.IsExpired(now) {
+		t.Log("QueueTask.ResolveExpiresAt() should resolve the TTL relative to EnqueuedAt")
+		t.Log("ExpiresAt:", qt.ExpiresAt)
+		t.Fail()
+	}
+}
+
+func TestQueueTaskWaitTime(t *testing.T) {
+	started := time.Unix(1500000000, 0)
+
+	if wait := (QueueTask{}).GetWaitTime(started); wait != 0 {
+		t.Log("QueueTask.GetWaitTime() should return 0 for tasks without EnqueuedAt")
+		t.Fail()
+	}
+	if wait := (QueueTask{EnqueuedAt: 1500000000 - 90}).GetWaitTime(started); wait != 90*time.Second {
+		t.Log("QueueTask.GetWaitTime() should return the time since EnqueuedAt")
+		t.Log("wait:", wait)
+		t.Fail()
+	}
+	if wait := (QueueTask{EnqueuedAt: 1500000000 - 90, RunAt: 1500000000 - 30}).GetWaitTime(started); wait != 30*time.Second {
+		t.Log("QueueTask.GetWaitTime() should return the time since RunAt for delayed and retried tasks")
+		t.Log("wait:", wait)
+		t.Fail()
+	}
+	if wait := (QueueTask{EnqueuedAt: 1500000000 - 90, RunAt: 1500000000 - 120}).GetWaitTime(started); wait != 90*time.Second {
+		t.Log("QueueTask.GetWaitTime() should ignore a RunAt before EnqueuedAt")
+		t.Log("wait:", wait)
+		t.Fail()
+	}
+	if wait := (QueueTask{EnqueuedAt: 1500000000 + 5}).GetWaitTime(started); wait != 0 {
+		t.Log("QueueTask.GetWaitTime() should not return negative durations on clock skew")
+		t.Fail()
+	}
+}
+
+func TestQueueTaskTimeout(t *testing.T) {
+	ct := config.Task{
+		Script:      "../testdata/sleep.sh",
+		Timeout:     1,
+		KillTimeout: 1,
+	}
+
+	qt := QueueTask{}
+	if qt.GetTimeout(ct) != time.Second {
+		t.Log("QueueTask.GetTimeout() should return the timeout of the task-config")
+		t.Fail()
+	}
+
+	qt.Timeout = 3
+	if qt.GetTimeout(ct) != 3*time.Second {
+		t.Log("QueueTask.GetTimeout() should prefer the timeout of the QueueTask")
+		t.Fail()
+	}
+
+	qt = QueueTask{Args: []string{"10"}}
+	start := time.Now()
+	err := qt.Execute(ct)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Log("QueueTask.Execute() should return a timeout error")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	// the script ignores SIGTERM, so it must be killed after the kill-timeout
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Log("QueueTask.Execute() should kill the process group after the kill-timeout")
+		t.Log("elapsed: ", elapsed)
+		t.Fail()
+	}
+}
+
+func TestQueueTaskInterrupted(t *testing.T) {
+	ct := config.Task{
+		Script:      "../testdata/sleep.sh",
+		KillTimeout: 1,
+	}
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		terminateProcesses(errorTaskInterrupted)
+	}()
+
+	qt := QueueTask{Args: []string{"10"}}
+	err := qt.Execute(ct)
+	if err != errorTaskInterrupted {
+		t.Log("QueueTask.Execute() should return errorTaskInterrupted for terminated processes")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	err = qt.Execute(ct)
+	if err != errorTaskInterrupted {
+		t.Log("QueueTask.Execute() should not start new processes after terminating all processes")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	processesLock.Lock()
+	processesTerminated = false
+	processesLock.Unlock()
+}
+
+func TestQueueTaskCancelled(t *testing.T) {
+	ct := config.Task{
+		Script:      "../testdata/sleep.sh",
+		KillTimeout: 1,
+	}
+
+	cancel := make(chan error, 1)
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		cancel <- errorTaskCancelled
+	}()
+
+	qt := QueueTask{Args: []string{"10"}}
+	start := time.Now()
+	_, err := qt.execute(ct, executeOptions{cancel: cancel})
+	if err != errorTaskCancelled {
+		t.Log("QueueTask.execute() should return errorTaskCancelled for cancelled processes")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Log("QueueTask.execute() should terminate the process group when cancelled")
+		t.Log("elapsed: ", elapsed)
+		t.Fail()
+	}
+}
+
+func TestQueueTaskFailureMode(t *testing.T) {
+	ct := config.Task{Script: "../testdata/output.sh"}
+
+	qt := QueueTask{Args: []string{"out", "err", "0"}}
+	err := qt.Execute(ct)
+	if err == nil || err.Error() != "out\nerr" {
+		t.Log("QueueTask.Execute() should include stdout and stderr in the error")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	ct.FailureMode = config.FailureModeExitCode
+	err = qt.Execute(ct)
+	if err != nil {
+		t.Log("QueueTask.Execute() should not return an error for output, when using exit_code")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	ct.FailureMode = config.FailureModeStderr
+	qt = QueueTask{Args: []string{"out", "", "0"}}
+	err = qt.Execute(ct)
+	if err != nil {
+		t.Log("QueueTask.Execute() should not return an error for stdout, when using stderr")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	qt = QueueTask{Args: []string{"", "err", "0"}}
+	err = qt.Execute(ct)
+	if err == nil || err.Error() != "err" {
+		t.Log("QueueTask.Execute() should return an error for stderr, when using stderr")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	ct.FailureMode = config.FailureModeExitCode
+	qt = QueueTask{Args: []string{"", "something broke", "3"}}
+	err = qt.Execute(ct)
+	if err == nil || err.Error() != "exit status 3\nsomething broke" {
+		t.Log("QueueTask.Execute() should return the exit status and stderr, when exiting with an error")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	ct.OutputLimit = 5
+	qt = QueueTask{Args: []string{"", "something broke", "3"}}
+	err = qt.Execute(ct)
+	if err == nil || err.Error() != "exit status 3\nsomet\n[output truncated]" {
+		t.Log("QueueTask.Execute() should truncate the output to the output limit")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+}
+
+func TestQueueTaskExitCode(t *testing.T) {
+	ct := config.Task{Script: "../testdata/output.sh"}
+
+	qt := QueueTask{Args: []string{"", "", "75"}}
+	err := qt.Execute(ct)
+	if getExitCode(err) != 75 {
+		t.Log("getExitCode() should return the exit-code of the script")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	qt = QueueTask{Args: []string{"out", "", "0"}}
+	err = qt.Execute(ct)
+	if err == nil || getExitCode(err) != 0 {
+		t.Log("getExitCode() should return 0 for tasks failing because of their output")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	ct.Script = "../testdata/doesnotexist"
+	err = qt.Execute(ct)
+	if getExitCode(err) != -1 {
+		t.Log("getExitCode() should return -1 for scripts that could not be executed")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+}
+
+func TestQueueTaskUniqueKey(t *testing.T) {
+	ct := config.Task{}
+	qt := QueueTask{Args: []string{"42"}, Env: map[string]string{"a": "b"}}
+	if qt.GetUniqueKey(ct) != "" {
+		t.Log("QueueTask.GetUniqueKey() should return an empty string for tasks that are not unique")
+		t.Fail()
+	}
+
+	qt.UniqueKey = "user-42"
+	if qt.GetUniqueKey(ct) != "user-42" {
+		t.Log("QueueTask.GetUniqueKey() should return the UniqueKey of the task")
+		t.Fail()
+	}
+
+	ct.Unique = config.UniqueArgs
+	if qt.GetUniqueKey(ct) != "user-42" {
+		t.Log("QueueTask.GetUniqueKey() should prefer the UniqueKey of the task over the unique rule")
+		t.Fail()
+	}
+
+	qt.UniqueKey = ""
+	key := qt.GetUniqueKey(ct)
+	if key == "" || key != (QueueTask{Args: []string{"42"}}).GetUniqueKey(ct) {
+		t.Log("QueueTask.GetUniqueKey() should derive the same key from the same arguments")
+		t.Fail()
+	}
+	if key == (QueueTask{Args: []string{"43"}}).GetUniqueKey(ct) {
+		t.Log("QueueTask.GetUniqueKey() should derive different keys from different arguments")
+		t.Fail()
+	}
+
+	ct.Unique = config.UniquePayload
+	if qt.GetUniqueKey(ct) == (QueueTask{Args: []string{"42"}}).GetUniqueKey(ct) {
+		t.Log("QueueTask.GetUniqueKey() should include the environment variables with the payload rule")
+		t.Fail()
+	}
+	if (QueueTask{}).GetUniqueKey(ct) != (QueueTask{Args: []string{}, Env: map[string]string{}}).GetUniqueKey(ct) {
+		t.Log("QueueTask.GetUniqueKey() should treat missing and empty arguments the same")
+		t.Fail()
+	}
+}
+
+func TestQueueTaskOutput(t *testing.T) {
+	ct := config.Task{Script: "../testdata/output.sh", FailureMode: config.FailureModeExitCode}
+
+	qt := QueueTask{Args: []string{"result", "", "0"}}
+	out, err := qt.ExecuteWithOutput(ct)
+	if err != nil || out == nil || out.Stdout != "result" || out.Truncated {
+		t.Log("QueueTask.ExecuteWithOutput() should return stdout of successful tasks")
+		t.Log("out: ", out, "err: ", err)
+		t.Fail()
+	}
+
+	ct.OutputLimit = 3
+	out, err = qt.ExecuteWithOutput(ct)
+	if err != nil || out == nil || out.Stdout != "res" || !out.Truncated {
+		t.Log("QueueTask.ExecuteWithOutput() should truncate the output to the output limit")
+		t.Log("out: ", out, "err: ", err)
+		t.Fail()
+	}
+
+	qt = QueueTask{Args: []string{"result", "", "1"}}
+	out, err = qt.ExecuteWithOutput(ct)
+	if err == nil || out != nil {
+		t.Log("QueueTask.ExecuteWithOutput() should only return an error for failed tasks")
+		t.Log("out: ", out, "err: ", err)
+		t.Fail()
+	}
+}
+
+func TestQueueTaskFollowUps(t *testing.T) {
+	ct := config.Task{Script: "../testdata/followup.sh"}
+
+	qt := QueueTask{Args: []string{`{"type":"something","args":["1"]}`, "", `{"type":"other"}`}}
+	out, err := qt.ExecuteWithOutput(ct)
+	if err != nil {
+		t.Log("QueueTask.ExecuteWithOutput() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	expected := []string{`{"type":"something","args":["1"]}`, `{"type":"other"}`}
+	if !reflect.DeepEqual(out.FollowUps, expected) {
+		t.Log("QueueTask.ExecuteWithOutput() should return the records written to the side-channel")
+		t.Log("expected: ", expected)
+		t.Log("returned: ", out.FollowUps)
+		t.Fail()
+	}
+}
+
+func TestQueueTaskProgress(t *testing.T) {
+	ct := config.Task{Script: "../testdata/progress.sh"}
+
+	var lines []string
+	qt := QueueTask{Args: []string{"progress 50", "status importing"}}
+	_, err := qt.execute(ct, executeOptions{progress: func(line []byte) {
+		lines = append(lines, string(line))
+	}})
+	if err != nil {
+		t.Log("QueueTask.execute() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	expected := []string{"progress 50", "status importing"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Log("QueueTask.execute() should pass the lines written to the progress side-channel")
+		t.Log("expected: ", expected)
+		t.Log("returned: ", lines)
+		t.Fail()
+	}
+}
@@ -0,0 +1,47 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for tasks with a rate limit, which is shared by all instances
+// through a token bucket in Redis.
+package taskqueue
+
+import (
+	"fmt"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"time"
+)
+
+// A rateLimitError is returned by fetchTask, when no task could be fetched because of the rate limit.
+type rateLimitError struct {
+	wait time.Duration // time until the next task may be fetched
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit exceeded, next task available in %s", e.wait)
+}
+
+func rateLimitKey(ct config.Task) string {
+	return conf.RedisQueueKey + ":" + ct.Type + ":ratelimit"
+}
+
+// rateLimitRefundScript returns a token to the bucket (KEYS[1]), which holds at most ARGV[1] tokens.
+// A bucket that expired in the meantime is full anyway.
+const rateLimitRefundScript = `
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+if tokens then
+	redis.call('HSET', KEYS[1], 'tokens', tostring(math.min(tonumber(ARGV[1]), tokens + 1)))
+end
+return 1
+`
+
+// refundRateLimitToken returns the token taken when the task was fetched, if the task is not
+// executed (e.g. because it was delayed or dropped), so only started tasks count.
+func refundRateLimitToken(ct config.Task) {
+	if ct.RatePerSecond <= 0 {
+		return
+	}
+
+	reply := redisPoolLua(3, rateLimitRefundScript, 1, rateLimitKey(ct), ct.RateBurst)
+	if reply.Err != nil {
+		output.NotifyError("refundRateLimitToken(), rateLimitRefundScript:", reply.Err)
+	}
+}
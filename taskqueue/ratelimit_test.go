@@ -0,0 +1,30 @@
+package taskqueue
+
+import (
+	"github.com/nevsnode/gordon/config"
+	"testing"
+)
+
+func TestRefundRateLimitToken(t *testing.T) {
+	defer testRedis(t)()
+
+	ct := config.Task{Type: "type", RatePerSecond: 1, RateBurst: 2}
+	redisPool.Cmd("HSET", rateLimitKey(ct), "tokens", "0.5", "time", "0")
+
+	for _, expected := range []float64{1.5, 2} {
+		refundRateLimitToken(ct)
+		tokens, err := redisPool.Cmd("HGET", rateLimitKey(ct), "tokens").Float64()
+		if err != nil || tokens != expected {
+			t.Log("refundRateLimitToken() should return a token, without exceeding the burst")
+			t.Log("expected: ", expected, "returned: ", tokens, "err: ", err)
+			t.Fail()
+		}
+	}
+
+	redisPool.Cmd("DEL", rateLimitKey(ct))
+	refundRateLimitToken(ct)
+	if n, _ := redisPool.Cmd("EXISTS", rateLimitKey(ct)).Int(); n != 0 {
+		t.Log("refundRateLimitToken() should not create an expired bucket")
+		t.Fail()
+	}
+}
@@ -0,0 +1,421 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for the reliable delivery of tasks, which are moved to
+// processing-lists of the fetching instance and re-queued when the instance died.
+package taskqueue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"os"
+	"sync"
+	"time"
+)
+
+// A processingTask references the raw value of a task in the processing-list
+// it was moved to. It stays there until the execution of the task has finished.
+type processingTask struct {
+	queueKey string // the list the task was fetched from
+	value    string // the raw value as it is stored in redis
+	slot     string // the member of the slot held in the semaphore of the task type, if any
+	lease    string // the token of the lease held on the concurrency key of the task, if any
+}
+
+// requeueScript moves all entries from a processing-list (KEYS[1]) back to the
+// head of their original list (KEYS[2]), keeping their order.
+const requeueScript = `
+local n = 0
+while redis.call('RPOPLPUSH', KEYS[1], KEYS[2]) do
+	n = n + 1
+end
+return n
+`
+
+// fetchScript moves the next task from the first non-empty list to its processing-list.
+// KEYS contains pairs of a list and its processing-list, in the order they shall be checked,
+// followed by the token bucket and the semaphore of the task type.
+// ARGV[1] and ARGV[2] are the rate in tokens per second and the size of the token bucket,
+// if the task has a rate limit (otherwise 0). A task is then only fetched when a token is available.
+// ARGV[3] is the maximum number of slots in the semaphore, if the task has a global worker limit
+// (otherwise 0). A task is then only fetched when a slot is free, which is leased to the member in
+// ARGV[4] for ARGV[5] seconds.
+// It returns the index of the list in KEYS and the task, nil when all lists are empty,
+// {0, milliseconds until the next token} when the rate limit is exceeded, or {-1} when no slot is free.
+const fetchScript = `
+local bucket, semaphore = KEYS[#KEYS - 1], KEYS[#KEYS]
+local rate, burst, slots = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local tokens
+if rate > 0 then
+	local state = redis.call('HMGET', bucket, 'tokens', 'time')
+	tokens = tonumber(state[1]) or burst
+	local last = tonumber(state[2]) or now
+	tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+
+	if tokens < 1 then
+		return {0, math.ceil((1 - tokens) / rate * 1000)}
+	end
+end
+
+if slots > 0 then
+	redis.call('ZREMRANGEBYSCORE', semaphore, '-inf', now)
+	if redis.call('ZCARD', semaphore) >= slots then
+		return {-1}
+	end
+end
+
+for i = 1, #KEYS - 2, 2 do
+	local value = redis.call('LMOVE', KEYS[i], KEYS[i + 1], 'LEFT', 'RIGHT')
+	if value then
+		if rate > 0 then
+			redis.call('HSET', bucket, 'tokens', tostring(tokens - 1), 'time', tostring(now))
+			redis.call('EXPIRE', bucket, math.ceil(burst / rate) + 1)
+		end
+		if slots > 0 then
+			redis.call('ZADD', semaphore, now + tonumber(ARGV[5]), ARGV[4])
+		end
+		return {i, value}
+	end
+end
+return false
+`
+
+// updateTaskScript replaces a task (ARGV[1]) in a processing-list (KEYS[1]) by ARGV[2].
+const updateTaskScript = `
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) > 0 then
+	redis.call('RPUSH', KEYS[1], ARGV[2])
+end
+return 1
+`
+
+// heartbeatScript marks an instance as alive by setting its key (KEYS[1]) to the timestamp in ARGV[1]
+// with the ttl ARGV[2]. It also adds the instance (ARGV[3]) to the registry (KEYS[2]) and the lists it
+// fetches tasks from (remaining ARGV) to its sources (KEYS[3]), as both are removed when the instance
+// was considered dead. It returns 1 when the instance was not registered.
+const heartbeatScript = `
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+if #ARGV > 3 then
+	redis.call('SADD', KEYS[3], unpack(ARGV, 4))
+end
+return redis.call('SADD', KEYS[2], ARGV[3])
+`
+
+// unregisterScript removes an instance (ARGV[1]) from the registry (KEYS[2]) and deletes its
+// sources (KEYS[3]), unless the instance is alive (KEYS[1]) again. It returns 1 when it was removed.
+const unregisterScript = `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('DEL', KEYS[3])
+redis.call('SREM', KEYS[2], ARGV[1])
+return 1
+`
+
+// requeueTaskScript moves a single task (ARGV[1]) from a processing-list (KEYS[1])
+// back to the head of its original list (KEYS[2]).
+const requeueTaskScript = `
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) > 0 then
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+end
+return 1
+`
+
+var (
+	instanceID         string
+	instanceHost       string
+	instanceSources    []interface{} // lists this instance might fetch tasks from
+	instanceRegistered bool          // true after the first heartbeat registered the instance
+	instanceStopChan   chan bool
+	waitGroupInst      sync.WaitGroup
+)
+
+func getHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return hostname
+}
+
+// createInstanceID returns an identifier that is unique for this process.
+func createInstanceID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	return fmt.Sprintf("%s:%d:%s", getHostname(), os.Getpid(), hex.EncodeToString(b))
+}
+
+// createToken returns a random identifier, for instance for locks held by a task.
+func createToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func instancesKey() string {
+	return conf.RedisQueueKey + ":instances"
+}
+
+func instanceKey(id string) string {
+	return conf.RedisQueueKey + ":instance:" + id
+}
+
+func instanceSourcesKey(id string) string {
+	return instanceKey(id) + ":sources"
+}
+
+func processingKey(queueKey, id string) string {
+	return queueKey + ":processing:" + id
+}
+
+// registerInstance announces this instance and all lists it might fetch tasks from,
+// and starts the go-routines that keep the instance alive and sweep after dead ones.
+func registerInstance() {
+	instanceID = createInstanceID()
+	instanceHost = getHostname()
+	instanceStopChan = make(chan bool)
+	output.Debug("Registering instance", instanceID)
+
+	instanceSources = []interface{}{workflowsKey()}
+	for _, ct := range conf.Tasks {
+		for _, queueKey := range taskQueueKeys(ct) {
+			instanceSources = append(instanceSources, queueKey)
+		}
+	}
+
+	sendHeartbeat()
+	instanceRegistered = true
+
+	sweepDeadInstances()
+
+	waitGroupInst.Add(1)
+	go instanceWorker()
+}
+
+// unregisterInstance stops the heartbeat of this instance and removes it, after
+// giving back tasks that might still be left in its processing-lists.
+func unregisterInstance() {
+	close(instanceStopChan)
+	waitGroupInst.Wait()
+
+	// without its key, other instances recover the tasks when this fails
+	reply := redisPoolCmd(3, "DEL", instanceKey(instanceID))
+	if reply.Err != nil {
+		output.NotifyError("unregisterInstance(), DEL:", reply.Err)
+		return
+	}
+
+	requeueInstance(instanceID)
+}
+
+func instanceWorker() {
+	defer waitGroupInst.Done()
+
+	heartbeat := time.NewTicker(time.Duration(conf.InstanceTimeout) * time.Second / 3)
+	sweep := time.NewTicker(time.Duration(conf.InstanceTimeout) * time.Second)
+	defer heartbeat.Stop()
+	defer sweep.Stop()
+
+	for {
+		select {
+		case <-instanceStopChan:
+			return
+		case <-heartbeat.C:
+			sendHeartbeat()
+			renewConcurrencyLeases()
+			renewSemaphoreSlots()
+		case <-sweep.C:
+			sweepDeadInstances()
+			sweepConcurrencyLeases()
+		}
+	}
+}
+
+// sendHeartbeat refreshes the key that marks this instance as alive, and registers the instance
+// again if another instance considered it dead, for instance after a Redis outage.
+func sendHeartbeat() {
+	added, err := redisPoolLua(3, heartbeatScript, 3, instanceKey(instanceID), instancesKey(), instanceSourcesKey(instanceID),
+		time.Now().Unix(), conf.InstanceTimeout, instanceID, instanceSources).Int()
+	if err != nil {
+		output.NotifyError("sendHeartbeat(), heartbeatScript:", err)
+		return
+	}
+
+	if added == 1 && instanceRegistered {
+		output.NotifyError("sendHeartbeat(): Instance", instanceID, "was considered dead and registered again, its running tasks might be executed twice")
+	}
+}
+
+// sweepDeadInstances re-queues the tasks of all instances that stopped sending heartbeats.
+func sweepDeadInstances() {
+	ids, err := redisPoolCmd(3, "SMEMBERS", instancesKey()).List()
+	if err != nil {
+		output.NotifyError("sweepDeadInstances(), SMEMBERS:", err)
+		return
+	}
+
+	for _, id := range ids {
+		if id == instanceID {
+			continue
+		}
+
+		alive, err := redisPoolCmd(3, "EXISTS", instanceKey(id)).Int()
+		if err != nil {
+			output.NotifyError("sweepDeadInstances(), EXISTS:", err)
+			return
+		}
+		if alive == 1 {
+			continue
+		}
+
+		output.Debug("Recovering tasks of dead instance", id)
+		requeueInstance(id)
+	}
+}
+
+// requeueInstance moves the tasks from all processing-lists of the given instance
+// back to their original lists and removes the instance from the registry.
+// It returns false, when this could not be done completely.
+func requeueInstance(id string) bool {
+	sources, err := redisPoolCmd(3, "SMEMBERS", instanceSourcesKey(id)).List()
+	if err != nil {
+		output.NotifyError("requeueInstance(), SMEMBERS:", err)
+		return false
+	}
+
+	for _, queueKey := range sources {
+		n, err := redisPoolLua(3, requeueScript, 2, processingKey(queueKey, id), queueKey).Int()
+		if err != nil {
+			output.NotifyError("requeueInstance(), requeueScript:", err)
+			return false
+		}
+		if n > 0 {
+			output.Debug("Re-queued", n, "tasks of instance", id, "to", queueKey)
+		}
+	}
+
+	// an instance that sent a heartbeat in the meantime stays registered, so its tasks remain recoverable
+	removed, err := redisPoolLua(3, unregisterScript, 3, instanceKey(id), instancesKey(), instanceSourcesKey(id), id).Int()
+	if err != nil {
+		output.NotifyError("requeueInstance(), unregisterScript:", err)
+		return false
+	}
+
+	return removed == 1
+}
+
+// fetchTask atomically moves the next task of the passed type from the first non-empty of its lists
+// to the processing-list of this instance. If the rate limit of the task is exceeded, a
+// rateLimitError is returned, and if all global workers are busy errorNoGlobalWorker.
+// In both cases the tasks remain in their lists.
+func fetchTask(ct config.Task) (pt processingTask, err error) {
+	queueKeys := fetchOrder(ct)
+	keys := make([]interface{}, 0, len(queueKeys)*2+2)
+	for _, queueKey := range queueKeys {
+		keys = append(keys, queueKey, processingKey(queueKey, instanceID))
+	}
+	keys = append(keys, rateLimitKey(ct), semaphoreKey(ct))
+
+	slot := ""
+	if ct.GlobalWorkers > 0 {
+		slot = instanceID + ":" + createToken()
+	}
+
+	reply := redisPoolLua(1, fetchScript, len(keys), keys, ct.RatePerSecond, ct.RateBurst, ct.GlobalWorkers, slot, conf.InstanceTimeout)
+	if reply.Err != nil {
+		err = reply.Err
+		return
+	}
+
+	pt.queueKey, pt.value, err = parseFetchReply(reply, queueKeys)
+	if err != nil {
+		return
+	}
+
+	pt.slot = slot
+	if slot != "" {
+		holdSemaphoreSlot(ct, slot)
+	}
+	return
+}
+
+// parseFetchReply returns the list and the raw value of the task fetched by the fetchScript,
+// or the according error when no task was fetched. The queueKeys are the lists in the order
+// they were passed to the script.
+func parseFetchReply(reply *redis.Resp, queueKeys []string) (queueKey string, value string, err error) {
+	if reply.IsType(redis.Nil) {
+		err = errorNoNewTask
+		return
+	}
+
+	values, err := reply.Array()
+	if err != nil {
+		return
+	}
+	if len(values) == 0 {
+		err = fmt.Errorf("Unexpected reply of fetchScript: %s", reply)
+		return
+	}
+
+	index, err := values[0].Int()
+	if err != nil {
+		return
+	}
+
+	switch {
+	case index == -1:
+		err = errorNoGlobalWorker
+		return
+	case index == 0 && len(values) == 2:
+		wait, _ := values[1].Int64()
+		err = &rateLimitError{wait: time.Duration(wait) * time.Millisecond}
+		return
+	case len(values) != 2 || index < 1 || (index-1)/2 >= len(queueKeys):
+		err = fmt.Errorf("Unexpected reply of fetchScript: %s", reply)
+		return
+	}
+
+	queueKey = queueKeys[(index-1)/2]
+	value, err = values[1].Str()
+	return
+}
+
+// updateTask replaces a task in the processing-list with its current values,
+// so they are kept when the task is re-queued.
+func updateTask(pt *processingTask, task QueueTask) error {
+	value, err := task.GetJSONString()
+	if err != nil {
+		return err
+	}
+
+	reply := redisPoolLua(3, updateTaskScript, 1, processingKey(pt.queueKey, instanceID), pt.value, value)
+	if reply.Err != nil {
+		return reply.Err
+	}
+
+	pt.value = value
+	return nil
+}
+
+// requeueTask moves a task from the processing-list back to its original list,
+// so it will be executed again.
+func requeueTask(pt processingTask) {
+	reply := redisPoolLua(3, requeueTaskScript, 2, processingKey(pt.queueKey, instanceID), pt.queueKey, pt.value)
+	if reply.Err != nil {
+		output.NotifyError("requeueTask(), requeueTaskScript:", reply.Err, "\nPayload:\n", pt.value)
+	}
+}
+
+// finishTask removes a task from the processing-list, after its execution finished.
+func finishTask(pt processingTask) {
+	reply := redisPoolCmd(3, "LREM", processingKey(pt.queueKey, instanceID), 1, pt.value)
+	if reply.Err != nil {
+		output.NotifyError("finishTask(), LREM:", reply.Err, "\nPayload:\n", pt.value)
+	}
+}
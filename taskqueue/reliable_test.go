@@ -0,0 +1,143 @@
+package taskqueue
+
+import (
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRedis connects to the Redis server in the environment variable GORDON_TEST_REDIS,
+// and uses a queue key that is removed after the test. Tests requiring Redis are skipped without it.
+func testRedis(t *testing.T) func() {
+	address := os.Getenv("GORDON_TEST_REDIS")
+	if address == "" {
+		t.Skip("GORDON_TEST_REDIS is not set")
+	}
+
+	var err error
+	redisPool, err = pool.New("tcp", address, 1)
+	if err != nil {
+		t.Log("pool.New() should not return an error")
+		t.Log("err: ", err)
+		t.FailNow()
+	}
+
+	previousConf, previousID := conf, instanceID
+	conf.RedisQueueKey = "gordon-test-" + createToken()
+	conf.InstanceTimeout = 30
+	return func() {
+		keys, _ := redisPool.Cmd("KEYS", conf.RedisQueueKey+":*").List()
+		if len(keys) > 0 {
+			redisPool.Cmd("DEL", keys)
+		}
+		redisPool.Empty()
+		conf, instanceID = previousConf, previousID
+	}
+}
+
+func TestParseFetchReply(t *testing.T) {
+	queueKeys := []string{"test:type:high", "test:type"}
+
+	queueKey, value, err := parseFetchReply(redis.NewResp([]interface{}{3, `{"args":[]}`}), queueKeys)
+	if err != nil || queueKey != "test:type" || value != `{"args":[]}` {
+		t.Log("parseFetchReply() should return the list and the value of the fetched task")
+		t.Log("queueKey: ", queueKey, "value: ", value, "err: ", err)
+		t.Fail()
+	}
+
+	if _, _, err = parseFetchReply(redis.NewResp(nil), queueKeys); err != errorNoNewTask {
+		t.Log("parseFetchReply() should return errorNoNewTask when all lists are empty")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	if _, _, err = parseFetchReply(redis.NewResp([]interface{}{-1}), queueKeys); err != errorNoGlobalWorker {
+		t.Log("parseFetchReply() should return errorNoGlobalWorker when no slot is free")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	_, _, err = parseFetchReply(redis.NewResp([]interface{}{0, 1500}), queueKeys)
+	if rateErr, ok := err.(*rateLimitError); !ok || rateErr.wait != 1500*time.Millisecond {
+		t.Log("parseFetchReply() should return a rateLimitError with the time until the next token")
+		t.Log("err: ", err)
+		t.Fail()
+	}
+
+	invalid := []interface{}{
+		[]interface{}{},
+		[]interface{}{1},
+		[]interface{}{5, "value"},
+		[]interface{}{"index", "value"},
+		"value",
+	}
+	for _, reply := range invalid {
+		if _, _, err = parseFetchReply(redis.NewResp(reply), queueKeys); err == nil {
+			t.Log("parseFetchReply() should return an error for unexpected replies")
+			t.Log("reply: ", reply)
+			t.Fail()
+		}
+	}
+}
+
+func TestRequeueOrder(t *testing.T) {
+	defer testRedis(t)()
+
+	queueKey := conf.RedisQueueKey + ":type"
+	redisPool.Cmd("RPUSH", processingKey(queueKey, "dead"), "1", "2", "3")
+	redisPool.Cmd("RPUSH", queueKey, "4", "5")
+
+	pt := processingTask{queueKey: queueKey, value: "0"}
+	redisPool.Cmd("RPUSH", processingKey(queueKey, instanceID), pt.value)
+
+	redisPool.Cmd("SADD", instanceSourcesKey("dead"), queueKey)
+	redisPool.Cmd("SADD", instancesKey(), "dead")
+	sweepDeadInstances()
+	requeueTask(pt)
+
+	values, err := redisPool.Cmd("LRANGE", queueKey, 0, -1).List()
+	expected := []string{"0", "1", "2", "3", "4", "5"}
+	if err != nil || !reflect.DeepEqual(values, expected) {
+		t.Log("Re-queued tasks should be moved to the head of their list, keeping their order")
+		t.Log("expected: ", expected)
+		t.Log("returned: ", values, "err: ", err)
+		t.Fail()
+	}
+
+	if n, _ := redisPool.Cmd("SISMEMBER", instancesKey(), "dead").Int(); n != 0 {
+		t.Log("sweepDeadInstances() should remove the dead instance from the registry")
+		t.Fail()
+	}
+}
+
+func TestHeartbeatRegistersAgain(t *testing.T) {
+	defer testRedis(t)()
+
+	instanceID = "alive"
+	instanceSources = []interface{}{conf.RedisQueueKey + ":type"}
+	sendHeartbeat()
+
+	// another instance considered this one dead, for instance after an outage
+	redisPool.Cmd("DEL", instanceKey(instanceID))
+	if !requeueInstance(instanceID) {
+		t.Log("requeueInstance() should remove an instance without heartbeat")
+		t.FailNow()
+	}
+
+	sendHeartbeat()
+	registered, _ := redisPool.Cmd("SISMEMBER", instancesKey(), instanceID).Int()
+	sources, _ := redisPool.Cmd("SMEMBERS", instanceSourcesKey(instanceID)).List()
+	if registered != 1 || len(sources) != 1 {
+		t.Log("sendHeartbeat() should register the instance and its sources again")
+		t.Log("registered: ", registered, "sources: ", sources)
+		t.Fail()
+	}
+
+	if requeueInstance(instanceID) {
+		t.Log("requeueInstance() should not remove an instance that is alive")
+		t.Fail()
+	}
+}
@@ -0,0 +1,62 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for storing the results of tasks, which callers can wait for.
+package taskqueue
+
+import (
+	"encoding/json"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+)
+
+// resultScript replaces the result of a task in a list (KEYS[1]) by ARGV[1],
+// which expires after ARGV[2] seconds. As the list only contains a single entry,
+// callers can wait for it with BLMOVE, without removing it.
+const resultScript = `
+redis.call('DEL', KEYS[1])
+redis.call('RPUSH', KEYS[1], ARGV[1])
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return 1
+`
+
+// A taskResult is the result of a task, as it is stored in Redis.
+type taskResult struct {
+	ID        string `json:"id"`                  // id of the task
+	Status    string `json:"status"`              // final status of the task
+	Result    string `json:"result"`              // output of the task, if it succeeded
+	Truncated bool   `json:"truncated,omitempty"` // true if the output was truncated to the output limit
+	Error     string `json:"error,omitempty"`     // error message, if the task did not succeed
+	ExitCode  int    `json:"exit_code"`           // exit-code of the script/application
+}
+
+func resultKey(id string) string {
+	return conf.RedisQueueKey + ":result:" + id
+}
+
+// storeTaskResult stores the result of a task after its final execution, if configured.
+func storeTaskResult(task QueueTask, ct config.Task, status string, out *TaskOutput, exitCode int) {
+	if !ct.StoreResult || task.ID == "" {
+		return
+	}
+
+	result := taskResult{
+		ID:       task.ID,
+		Status:   status,
+		Error:    task.ErrorMessage,
+		ExitCode: exitCode,
+	}
+	if out != nil {
+		result.Result = out.Stdout
+		result.Truncated = out.Truncated
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		output.NotifyError("storeTaskResult(), json.Marshal():", err)
+		return
+	}
+
+	reply := redisPoolLua(3, resultScript, 1, resultKey(task.ID), string(b), ct.ResultTTL)
+	if reply.Err != nil {
+		output.NotifyError("storeTaskResult(), resultScript:", reply.Err, "\nTask:", task.ID)
+	}
+}
@@ -0,0 +1,101 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for delayed and retried tasks, which are stored in a
+// sorted set until they are due and then promoted to their list.
+package taskqueue
+
+import (
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"time"
+)
+
+const (
+	scheduleInterval = time.Second // interval for checking for due tasks
+	scheduleLimit    = 1000        // maximum number of tasks promoted per set and interval
+)
+
+// promoteScript moves the due entries of the scheduled sets to their lists.
+// KEYS contains pairs of a scheduled set and the list it belongs to,
+// ARGV[1] is the current timestamp and ARGV[2] the maximum amount of entries per set.
+const promoteScript = `
+local n = 0
+for i = 1, #KEYS, 2 do
+	local due = redis.call('ZRANGEBYSCORE', KEYS[i], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+	for _, value in ipairs(due) do
+		redis.call('ZREM', KEYS[i], value)
+		redis.call('RPUSH', KEYS[i + 1], value)
+		n = n + 1
+	end
+end
+return n
+`
+
+// scheduleScript moves a task from a processing-list (KEYS[1]) to a scheduled set (KEYS[2]).
+// ARGV[1] is the raw value in the processing-list, ARGV[2] the timestamp the task
+// shall be executed at and ARGV[3] the value stored in the scheduled set.
+const scheduleScript = `
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[3])
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+return 1
+`
+
+func scheduledKey(queueKey string) string {
+	return queueKey + ":scheduled"
+}
+
+// scheduleTask removes a task from the processing-list and stores it in the scheduled
+// set of the list it was fetched from, where it remains until the timestamp in its RunAt is reached.
+// As members of sorted sets are unique, the task gets a new nonce, so identical tasks are all kept.
+func scheduleTask(pt processingTask, task QueueTask) error {
+	task.Nonce = createToken()
+	value, err := task.GetJSONString()
+	if err != nil {
+		return err
+	}
+
+	reply := redisPoolLua(3, scheduleScript, 2, processingKey(pt.queueKey, instanceID), scheduledKey(pt.queueKey), pt.value, task.RunAt, value)
+	return reply.Err
+}
+
+// retryTask schedules a failed task to be executed again, after the passed delay in seconds.
+func retryTask(pt processingTask, task QueueTask, ct config.Task, delay int) {
+	task.RunAt = time.Now().Unix() + int64(delay)
+
+	// unique tasks keep their lock, until they were retried
+	refreshUniqueTask(task, ct, delay)
+
+	err := scheduleTask(pt, task)
+	if err != nil {
+		output.NotifyError("retryTask(), scheduleTask():", err, "\nPayload:\n", pt.value)
+	}
+}
+
+func scheduledTaskWorker() {
+	keys := make([]interface{}, 0, len(conf.Tasks)*2)
+	for _, ct := range conf.Tasks {
+		for _, queueKey := range taskQueueKeys(ct) {
+			keys = append(keys, scheduledKey(queueKey), queueKey)
+		}
+	}
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if isShuttingDown() {
+			break
+		}
+
+		n, err := redisPoolLua(1, promoteScript, len(keys), keys, time.Now().Unix(), scheduleLimit).Int()
+		if err != nil {
+			output.NotifyError("scheduledTaskWorker(), promoteScript:", err)
+			continue
+		}
+
+		if n > 0 {
+			output.Debug("Promoted", n, "scheduled tasks")
+		}
+	}
+
+	output.Debug("Finished scheduled-task-worker")
+}
@@ -0,0 +1,29 @@
+package taskqueue
+
+import (
+	"testing"
+)
+
+func TestScheduleIdenticalTasks(t *testing.T) {
+	defer testRedis(t)()
+
+	queueKey := conf.RedisQueueKey + ":type"
+	task := QueueTask{Args: []string{"x"}, RunAt: 1500000000}
+	value, _ := task.GetJSONString()
+
+	for i := 0; i < 2; i++ {
+		pt := processingTask{queueKey: queueKey, value: value}
+		redisPool.Cmd("RPUSH", processingKey(queueKey, instanceID), value)
+		if err := scheduleTask(pt, task); err != nil {
+			t.Log("scheduleTask() should not return an error")
+			t.Log("err: ", err)
+			t.FailNow()
+		}
+	}
+
+	if n, _ := redisPool.Cmd("ZCARD", scheduledKey(queueKey)).Int(); n != 2 {
+		t.Log("scheduleTask() should keep identical tasks scheduled for the same time")
+		t.Log("scheduled: ", n)
+		t.Fail()
+	}
+}
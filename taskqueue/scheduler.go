@@ -0,0 +1,65 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for distributing the fetching of new tasks fairly between the task types.
+package taskqueue
+
+import (
+	"github.com/nevsnode/gordon/config"
+	"sort"
+)
+
+// A fairScheduler decides in which order the task types are checked for new tasks.
+// The types are picked by a weighted round-robin on their configured weight, so a type
+// with lots of tasks can not delay the others, no matter in which order they are configured.
+type fairScheduler struct {
+	types  []string
+	limits []int
+	picker *weightedRoundRobin
+}
+
+// newFairScheduler returns a fairScheduler for the passed tasks. Types with the same
+// weight are picked in the order of their names, so the order is always the same.
+func newFairScheduler(tasks map[string]config.Task) *fairScheduler {
+	types := make([]string, 0, len(tasks))
+	for taskType := range tasks {
+		types = append(types, taskType)
+	}
+	sort.Strings(types)
+
+	limits := make([]int, len(types))
+	weights := make([]int, len(types))
+	for i, taskType := range types {
+		// fetch a maximum of 2 * workers per round, so that tasks waiting
+		// for the error-backoff don't fill up the processing-lists
+		limits[i] = tasks[taskType].Workers * 2
+		weights[i] = tasks[taskType].Weight
+	}
+
+	return &fairScheduler{
+		types:  types,
+		limits: limits,
+		picker: newWeightedRoundRobin(weights),
+	}
+}
+
+// run executes a round of fetching new tasks. It calls fetch with the picked task types,
+// until every type returned false, which means that no more tasks can be fetched for it,
+// or reached its limit for this round. The state of the round-robin is kept between
+// the rounds, so the types are treated fairly across rounds too.
+func (s *fairScheduler) run(fetch func(taskType string) bool) {
+	fetched := make([]int, len(s.types))
+	done := make([]bool, len(s.types))
+	remaining := len(s.types)
+
+	for remaining > 0 {
+		i := s.picker.next()
+		if done[i] {
+			continue
+		}
+
+		fetched[i]++
+		if !fetch(s.types[i]) || fetched[i] >= s.limits[i] {
+			done[i] = true
+			remaining--
+		}
+	}
+}
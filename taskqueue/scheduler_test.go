@@ -0,0 +1,148 @@
+package taskqueue
+
+import (
+	"github.com/nevsnode/gordon/config"
+	"reflect"
+	"testing"
+)
+
+func testScheduler(weights map[string]int, workers int) *fairScheduler {
+	tasks := make(map[string]config.Task)
+	for taskType, weight := range weights {
+		tasks[taskType] = config.Task{
+			Type:    taskType,
+			Workers: workers,
+			Weight:  weight,
+		}
+	}
+	return newFairScheduler(tasks)
+}
+
+func TestFairSchedulerOrder(t *testing.T) {
+	weights := map[string]int{"c": 1, "a": 1, "b": 1}
+
+	var first []string
+	for i := 0; i < 10; i++ {
+		var order []string
+		testScheduler(weights, 1).run(func(taskType string) bool {
+			order = append(order, taskType)
+			return true
+		})
+
+		if first == nil {
+			first = order
+		}
+		if !reflect.DeepEqual(order, first) {
+			t.Log("fairScheduler.run() should always check the types in the same order")
+			t.Log("first:", first)
+			t.Log("order:", order)
+			t.FailNow()
+		}
+	}
+
+	expected := []string{"a", "b", "c", "a", "b", "c"}
+	if !reflect.DeepEqual(first, expected) {
+		t.Log("fairScheduler.run() should alternate between types with the same weight")
+		t.Log("expected:", expected)
+		t.Log("order:", first)
+		t.Fail()
+	}
+}
+
+func TestFairSchedulerWeights(t *testing.T) {
+	s := testScheduler(map[string]int{"bulk": 1, "urgent": 3}, 100)
+
+	picks := 0
+	fetched := make(map[string]int)
+	s.run(func(taskType string) bool {
+		if picks++; picks > 80 {
+			return false
+		}
+		fetched[taskType]++
+		return true
+	})
+
+	if fetched["urgent"] != 3*fetched["bulk"] {
+		t.Log("fairScheduler.run() should fetch the types according to their weights")
+		t.Log("fetched:", fetched)
+		t.Fail()
+	}
+}
+
+func TestFairSchedulerLimits(t *testing.T) {
+	s := testScheduler(map[string]int{"a": 1, "b": 1}, 2)
+
+	fetched := make(map[string]int)
+	s.run(func(taskType string) bool {
+		fetched[taskType]++
+		return true
+	})
+
+	if fetched["a"] != 4 || fetched["b"] != 4 {
+		t.Log("fairScheduler.run() should fetch at most 2 * workers tasks per type and round")
+		t.Log("fetched:", fetched)
+		t.Fail()
+	}
+
+	fetched = make(map[string]int)
+	s.run(func(taskType string) bool {
+		fetched[taskType]++
+		return taskType != "a"
+	})
+
+	if fetched["a"] != 1 || fetched["b"] != 4 {
+		t.Log("fairScheduler.run() should not check types again, after they returned false")
+		t.Log("fetched:", fetched)
+		t.Fail()
+	}
+}
+
+func TestFairSchedulerStarvation(t *testing.T) {
+	weights := map[string]int{"bulk": 10, "other": 1, "rare": 1}
+	s := testScheduler(weights, 50)
+
+	// bulk always has tasks available, the others get a new task every few rounds,
+	// which needs to be fetched before the round is over
+	queued := map[string]int{}
+	for round := 0; round < 100; round++ {
+		if round%3 == 0 {
+			queued["other"]++
+		}
+		if round%7 == 0 {
+			queued["rare"]++
+		}
+
+		picks := 0
+		waited := map[string]int{}
+		s.run(func(taskType string) bool {
+			picks++
+			if taskType == "bulk" {
+				return true
+			}
+			if queued[taskType] == 0 {
+				return false
+			}
+
+			waited[taskType] = picks
+			queued[taskType]--
+			return queued[taskType] > 0
+		})
+
+		for taskType, n := range queued {
+			if n > 0 {
+				t.Log("fairScheduler.run() should fetch all tasks of a type while another one has sustained load")
+				t.Log("round:", round, "type:", taskType, "remaining:", n)
+				t.FailNow()
+			}
+		}
+
+		// a single task should be fetched within the first cycle of the round-robin
+		for taskType, pick := range waited {
+			if pick > 12 {
+				t.Log("fairScheduler.run() should not delay types behind a type with sustained load")
+				t.Log("round:", round, "type:", taskType, "pick:", pick)
+				t.FailNow()
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for tasks with a global worker limit, which is shared by all
+// instances through a semaphore in Redis. The semaphore is a sorted set of leased slots,
+// scored by the time their lease expires.
+package taskqueue
+
+import (
+	"fmt"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"sync"
+)
+
+// semaphoreRenewScript extends the lease of a slot (ARGV[1]) in a semaphore (KEYS[1]) by ARGV[2] seconds,
+// if the slot still exists.
+const semaphoreRenewScript = `
+local time = redis.call('TIME')
+redis.call('ZADD', KEYS[1], 'XX', tonumber(time[1]) + tonumber(ARGV[2]), ARGV[1])
+return 1
+`
+
+var (
+	errorNoGlobalWorker = fmt.Errorf("No global worker available")
+
+	semaphoreSlots     = make(map[string]string) // semaphores of the slots held by this instance
+	semaphoreSlotsLock sync.Mutex
+)
+
+func semaphoreKey(ct config.Task) string {
+	return conf.RedisQueueKey + ":" + ct.Type + ":semaphore"
+}
+
+// holdSemaphoreSlot registers a slot acquired by fetchTask, so its lease is renewed with the heartbeat.
+func holdSemaphoreSlot(ct config.Task, slot string) {
+	semaphoreSlotsLock.Lock()
+	semaphoreSlots[slot] = semaphoreKey(ct)
+	semaphoreSlotsLock.Unlock()
+}
+
+// releaseSemaphoreSlot frees a slot of the semaphore, after the execution of the task finished.
+func releaseSemaphoreSlot(ct config.Task, slot string) {
+	if slot == "" {
+		return
+	}
+
+	semaphoreSlotsLock.Lock()
+	delete(semaphoreSlots, slot)
+	semaphoreSlotsLock.Unlock()
+
+	reply := redisPoolCmd(3, "ZREM", semaphoreKey(ct), slot)
+	if reply.Err != nil {
+		output.NotifyError("releaseSemaphoreSlot(), ZREM:", reply.Err)
+	}
+}
+
+// renewSemaphoreSlots extends the leases of all slots held by this instance, together with the heartbeat.
+// When an instance dies, its slots expire after the instance_timeout.
+func renewSemaphoreSlots() {
+	slots := make(map[string]string)
+	semaphoreSlotsLock.Lock()
+	for slot, key := range semaphoreSlots {
+		slots[slot] = key
+	}
+	semaphoreSlotsLock.Unlock()
+
+	for slot, key := range slots {
+		reply := redisPoolLua(3, semaphoreRenewScript, 1, key, slot, conf.InstanceTimeout)
+		if reply.Err != nil {
+			output.NotifyError("renewSemaphoreSlots(), semaphoreRenewScript:", reply.Err)
+		}
+	}
+}
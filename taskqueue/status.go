@@ -0,0 +1,65 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for tracking the status of tasks, which is stored in a hash per task.
+package taskqueue
+
+import (
+	"fmt"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"time"
+)
+
+// Available values for the status of a task.
+const (
+	statusQueued    = "queued"    // the task waits for being executed
+	statusRunning   = "running"   // the task is being executed
+	statusSucceeded = "succeeded" // the task was executed successfully
+	statusFailed    = "failed"    // the task failed permanently
+	statusDiscarded = "discarded" // the task was dropped, without being executed successfully
+	statusCancelled = "cancelled" // the task was cancelled by a client, before or while being executed
+)
+
+// statusScript updates the status hash of a task (KEYS[1]). ARGV[1] is its ttl, ARGV[2] the current
+// timestamp, ARGV[3] the status and the remaining arguments are pairs of further fields and values.
+// The values of the last execution are removed, when the task is running again.
+const statusScript = `
+redis.call('HSETNX', KEYS[1], 'queued_at', ARGV[2])
+if ARGV[3] == 'running' then
+	redis.call('HDEL', KEYS[1], 'finished_at', 'duration', 'exit_code', 'error', 'progress', 'message', 'heartbeat_at', 'stalled')
+end
+redis.call('HSET', KEYS[1], 'status', ARGV[3], 'updated_at', ARGV[2], unpack(ARGV, 4))
+redis.call('EXPIRE', KEYS[1], ARGV[1])
+return 1
+`
+
+func statusKey(id string) string {
+	return conf.RedisQueueKey + ":task:" + id
+}
+
+// isStatusTracked returns true, if the status of the task shall be stored.
+func isStatusTracked(task QueueTask, ct config.Task) bool {
+	return ct.StatusTTL > 0 && task.ID != ""
+}
+
+// setTaskStatus stores the status of a task, together with the passed fields.
+func setTaskStatus(task QueueTask, ct config.Task, status string, fields map[string]interface{}) {
+	if !isStatusTracked(task, ct) {
+		return
+	}
+
+	reply := redisPoolLua(3, statusScript, 1, statusKey(task.ID), ct.StatusTTL, time.Now().Unix(), status, fields)
+	if reply.Err != nil {
+		output.NotifyError("setTaskStatus(), statusScript:", reply.Err, "\nTask:", task.ID)
+	}
+}
+
+// setTaskFinished stores the status of a task after its execution.
+func setTaskFinished(task QueueTask, ct config.Task, status string, started time.Time, exitCode int) {
+	setTaskStatus(task, ct, status, map[string]interface{}{
+		"finished_at": time.Now().Unix(),
+		"duration":    fmt.Sprintf("%.3f", time.Since(started).Seconds()),
+		"exit_code":   exitCode,
+		"attempts":    task.Attempts,
+		"error":       task.ErrorMessage,
+	})
+}
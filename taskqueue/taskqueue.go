@@ -7,6 +7,7 @@ import (
 	"github.com/jpillora/backoff"
 	"github.com/mediocregopher/radix.v2/pool"
 	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/mediocregopher/radix.v2/util"
 	"github.com/nevsnode/gordon/config"
 	"github.com/nevsnode/gordon/output"
 	"github.com/nevsnode/gordon/stats"
@@ -20,6 +21,54 @@ type failedTask struct {
 	queueTask  QueueTask
 }
 
+// failedTasksTrimInterval is the interval for removing expired entries from the failed-lists.
+const failedTasksTrimInterval = time.Minute
+
+// failedScript adds a failed task (ARGV[1], if not empty) to the failed-list (KEYS[1]).
+// Entries from the head of the list whose timestamp in the property ARGV[6] (failed_at)
+// is before the timestamp in ARGV[2] are removed, as well as all entries exceeding the
+// maximum length in ARGV[3] (if greater than 0). Entries without the property, like the
+// ones stored by earlier versions, get the current timestamp in ARGV[5] and are moved to
+// the tail, so they expire after the time-to-live as well, without keeping the entries
+// behind them. Entries that are no tasks are removed.
+// When adding a task, the ttl of the whole list is set to ARGV[4].
+// The expired-lists are limited by this script as well, with the property expired_at.
+const failedScript = `
+if ARGV[1] ~= '' then
+	redis.call('RPUSH', KEYS[1], ARGV[1])
+	redis.call('EXPIRE', KEYS[1], ARGV[4])
+end
+
+local oldest = tonumber(ARGV[2])
+for i = 1, redis.call('LLEN', KEYS[1]) do
+	local head = redis.call('LINDEX', KEYS[1], 0)
+	local ok, entry = pcall(cjson.decode, head)
+	if not ok or type(entry) ~= 'table' or not string.find(head, '^%s*{') then
+		redis.call('LPOP', KEYS[1])
+	elseif not tonumber(entry[ARGV[6]]) then
+		-- the entry is not re-encoded, as cjson can not tell empty arrays from objects
+		local stamped = '{"' .. ARGV[6] .. '":' .. ARGV[5]
+		if next(entry) ~= nil then
+			stamped = stamped .. ','
+		end
+		stamped = stamped .. string.gsub(head, '^%s*{', '', 1)
+
+		redis.call('LPOP', KEYS[1])
+		redis.call('RPUSH', KEYS[1], stamped)
+	elseif tonumber(entry[ARGV[6]]) < oldest then
+		redis.call('LPOP', KEYS[1])
+	else
+		break
+	end
+end
+
+local max = tonumber(ARGV[3])
+if max > 0 then
+	redis.call('LTRIM', KEYS[1], -max, -1)
+end
+return 1
+`
+
 var (
 	errorNoNewTask          = fmt.Errorf("No new task available")
 	errorNoNewTasksAccepted = fmt.Errorf("No new tasks accepted")
@@ -30,6 +79,11 @@ var (
 	waitGroupFailed sync.WaitGroup
 	redisPool       *pool.Pool
 	failedChan      chan failedTask
+
+	// the workers serving the running tasks are stopped by closing tasksDoneChan,
+	// after all task workers finished
+	tasksDoneChan      chan bool
+	waitGroupTasksDone sync.WaitGroup
 )
 
 func init() {
@@ -50,15 +104,36 @@ func Start(c config.Config) {
 	stats.InitTasks(conf.Tasks)
 
 	failedChan = make(chan failedTask)
+	tasksDoneChan = make(chan bool)
 	shutdownChan = make(chan bool, 1)
+	wakeupChan = make(chan bool, 1)
+	notifyKeys = make(map[string]bool)
 
 	for _, ct := range conf.Tasks {
 		createWorkerCount(ct.Type)
+		for _, queueKey := range taskQueueKeys(ct) {
+			addNotifyKey(queueKey)
+		}
 	}
 
+	registerInstance()
+
 	waitGroupFailed.Add(1)
 	go failedTaskWorker()
 
+	if conf.FetchMode == config.FetchModeNotify {
+		go notifyWorker()
+	}
+
+	go scheduledTaskWorker()
+	go cronWorker()
+	waitGroup.Add(1)
+	go workflowWorker()
+	waitGroupTasksDone.Add(1)
+	go progressWorker()
+	waitGroupTasksDone.Add(1)
+	go cancelWorker()
+
 	waitGroup.Add(1)
 	go queueWorker()
 }
@@ -72,6 +147,14 @@ func Stop() {
 
 	setShutdown()
 	shutdownChan <- true
+
+	// running tasks that do not finish within the shutdown-timeout are interrupted
+	if conf.ShutdownTimeout > 0 {
+		time.AfterFunc(time.Duration(conf.ShutdownTimeout)*time.Second, func() {
+			output.Debug("Shutdown timeout reached, interrupting running tasks")
+			terminateProcesses(errorTaskInterrupted)
+		})
+	}
 }
 
 // Wait waits, to keep the application running as long as there are workers
@@ -79,9 +162,15 @@ func Wait() {
 	waitGroup.Wait()
 	output.Debug("Finished task-workers")
 
+	close(tasksDoneChan)
+	waitGroupTasksDone.Wait()
+
 	close(failedChan)
 	waitGroupFailed.Wait()
 	output.Debug("Finished failed-task-worker")
+
+	unregisterInstance()
+	output.Debug("Unregistered instance")
 }
 
 func queueWorker() {
@@ -91,13 +180,23 @@ func queueWorker() {
 		Factor: conf.IntervalFactor,
 	}
 
+	// When being notified about new tasks, polling is just a fallback,
+	// so there is no need to check more often than in the maximum interval.
+	if conf.FetchMode == config.FetchModeNotify {
+		interval.Min = interval.Max
+	}
+
 	runIntervalLoop := make(chan bool)
 	doneIntervalLoop := make(chan bool)
 
 	go func() {
 		for {
 			<-doneIntervalLoop
-			time.Sleep(interval.Duration())
+
+			select {
+			case <-time.After(interval.Duration()):
+			case <-wakeupChan:
+			}
 
 			if isShuttingDown() {
 				break
@@ -118,75 +217,188 @@ func queueWorker() {
 
 	doneIntervalLoop <- true
 
-intervalLoop:
+	scheduler := newFairScheduler(conf.Tasks)
+
 	for <-runIntervalLoop {
-		for taskType, configTask := range conf.Tasks {
+		scheduler.run(func(taskType string) bool {
 			if isShuttingDown() {
-				break intervalLoop
+				return false
 			}
 
-			output.Debug("Checking for new tasks (" + taskType + ")")
-
-			// check if there are available workers
-			if !isWorkerAvailable(taskType) {
-				continue
+			started, more := fetchNextTask(conf.Tasks[taskType])
+			if started {
+				// we've actually are handling new tasks so reset the interval
+				interval.Reset()
 			}
+			return more
+		})
 
-			queueKey := conf.RedisQueueKey + ":" + taskType
+		if isShuttingDown() {
+			break
+		}
 
-			llen, err := redisPoolCmd(3, "LLEN", queueKey).Int()
-			if err != nil {
-				// Errors here are likely redis-connection errors, so we'll
-				// need to notify about it
-				output.NotifyError("redisPoolCmd() Error:", err)
-				break
-			}
+		doneIntervalLoop <- true
+	}
+
+	Stop()
+	waitGroup.Done()
+	output.Debug("Finished queue-worker")
+}
 
-			// there are no new tasks in redis
-			if llen == 0 {
-				continue
+// fetchNextTask fetches the next task of the passed type and starts a worker for it.
+// It returns whether a worker was started, and whether more tasks may be fetched for
+// this type in the current round.
+func fetchNextTask(configTask config.Task) (started bool, more bool) {
+	taskType := configTask.Type
+
+	// the slot of the global worker limit and the token of the rate limit are only kept, when the task is executed
+	var pt processingTask
+	defer func() {
+		if !started {
+			releaseSemaphoreSlot(configTask, pt.slot)
+			if pt.value != "" {
+				refundRateLimitToken(configTask)
 			}
+		}
+	}()
 
-			// iterate over all entries in redis, until no more are available,
-			// or all workers are busy, for a maximum of 2 * workers
-			for i := 0; i < (configTask.Workers * 2); i++ {
-				if !isWorkerAvailable(taskType) {
-					break
-				}
+	output.Debug("Checking for new tasks (" + taskType + ")")
 
-				value, err := redisPoolCmd(1, "LPOP", queueKey).Str()
-				if err != nil {
-					// most likely no more tasks found
-					break
-				}
+	// check if there are available workers
+	if !isWorkerAvailable(taskType) {
+		// a type whose cost exceeds the remaining budget reserves the budget that becomes free
+		if exceedsWorkerBudget(taskType) && hasQueuedTasks(configTask) {
+			output.Debug("Reserving the worker budget for type", taskType)
+			reserveWorkerBudget(taskType)
+		}
+		return false, false
+	}
 
-				output.Debug("Fetched task for type", taskType, "with payload", value)
+	pt, err := fetchTask(configTask)
+	if err == errorNoNewTask {
+		return false, false
+	}
+	if err == errorNoGlobalWorker {
+		// slots of other instances are not announced, so check again soon
+		output.Debug("No global worker available for type", taskType)
+		time.AfterFunc(scheduleInterval, wakeup)
+		return false, false
+	}
+	if rateErr, ok := err.(*rateLimitError); ok {
+		// throttled tasks remain in their lists, check again when the next one is available
+		output.Debug("Rate limit of type", taskType, "exceeded, waiting", rateErr.wait)
+		time.AfterFunc(rateErr.wait, wakeup)
+		return false, false
+	}
+	if err != nil {
+		// Errors here are likely redis-connection errors, so we'll
+		// need to notify about it. The other types are still checked.
+		output.NotifyError("fetchTask() Error:", err)
+		return false, false
+	}
 
-				task, err := NewQueueTask(value)
-				if err != nil {
-					output.NotifyError("NewQueueTask():", err)
-					continue
-				}
+	output.Debug("Fetched task for type", taskType, "with payload", pt.value)
 
-				// spawn worker go-routine
-				claimWorker(taskType)
-				go taskWorker(task, configTask)
+	task, err := NewQueueTask(pt.value)
+	if err != nil {
+		output.NotifyError("NewQueueTask():", err)
+		finishTask(pt)
+		return false, true
+	}
 
-				// we've actually are handling new tasks so reset the interval
-				interval.Reset()
-			}
+	// tasks whose status or result is stored need an id, and relative expiry
+	// deadlines are resolved, so both are kept when the task is re-queued
+	now := time.Now().Unix()
+	changed := task.ResolveExpiresAt(configTask, now)
+	if (configTask.StatusTTL > 0 || configTask.StoreResult) && task.ID == "" {
+		task.ID = createToken()
+		changed = true
+	}
+	if changed {
+		if err = updateTask(&pt, task); err != nil {
+			output.NotifyError("updateTask():", err, "\nPayload:\n", pt.value)
+			requeueTask(pt)
+			return false, false
 		}
+	}
 
-		doneIntervalLoop <- true
+	// duplicates of unique tasks are dropped, while another one is queued or running
+	locked, err := lockUniqueTask(&pt, &task, configTask)
+	if err != nil {
+		output.NotifyError("lockUniqueTask():", err, "\nPayload:\n", pt.value)
+		requeueTask(pt)
+		return false, false
+	}
+	if !locked {
+		output.Debug("Dropped duplicate task for type", taskType, "with payload", pt.value)
+		task.ErrorMessage = "duplicate"
+		setTaskStatus(task, configTask, statusDiscarded, map[string]interface{}{"error": task.ErrorMessage})
+		storeTaskResult(task, configTask, statusDiscarded, nil, -1)
+		finishWorkflowStep(task, statusDiscarded)
+		return false, true
 	}
 
-	Stop()
-	waitGroup.Done()
-	output.Debug("Finished queue-worker")
+	// cancelled tasks are dropped instead of being executed
+	cancelled, err := isTaskCancelled(task)
+	if err != nil {
+		output.NotifyError("isTaskCancelled():", err, "\nPayload:\n", pt.value)
+		requeueTask(pt)
+		return false, false
+	}
+	if cancelled {
+		output.Debug("Cancelled task for type", taskType, "with payload", pt.value)
+		task.ErrorMessage = errorTaskCancelled.Error()
+		setTaskStatus(task, configTask, statusCancelled, map[string]interface{}{
+			"finished_at": time.Now().Unix(),
+			"error":       task.ErrorMessage,
+		})
+		finishCancelledTask(pt, task, configTask, -1)
+		return false, true
+	}
+
+	// stale tasks are dropped instead of being executed
+	if task.IsExpired(now) {
+		output.Debug("Expired task for type", taskType, "with payload", pt.value)
+		expireTask(pt, task, configTask)
+		return false, true
+	}
+
+	// delayed tasks are stored in the scheduled set, until they are due
+	if !task.ResolveRunAt(now) {
+		output.Debug("Scheduling task for type", taskType, "to run at", task.RunAt)
+		refreshUniqueTask(task, configTask, int(task.RunAt-now))
+
+		err = scheduleTask(pt, task)
+		if err != nil {
+			output.NotifyError("scheduleTask():", err, "\nPayload:\n", pt.value)
+		}
+		setTaskStatus(task, configTask, statusQueued, map[string]interface{}{"run_at": task.RunAt})
+		return false, true
+	}
+
+	// tasks with a concurrency key wait, while another task with the same key is running
+	acquired, err := acquireConcurrencyLease(&pt, task)
+	if err != nil {
+		output.NotifyError("acquireConcurrencyLease():", err, "\nPayload:\n", pt.value)
+		requeueTask(pt)
+		return false, false
+	}
+	if !acquired {
+		output.Debug("Task for type", taskType, "is waiting for concurrency key", task.ConcurrencyKey)
+		setTaskStatus(task, configTask, statusQueued, nil)
+		return false, true
+	}
+
+	// spawn worker go-routine
+	claimWorker(taskType)
+	go taskWorker(task, configTask, pt)
+	return true, true
 }
 
-func taskWorker(task QueueTask, ct config.Task) {
+func taskWorker(task QueueTask, ct config.Task, pt processingTask) {
 	defer returnWorker(ct.Type)
+	defer releaseSemaphoreSlot(ct, pt.slot)
+	defer releaseConcurrencyLease(task, pt.lease)
 
 	if ct.BackoffEnabled {
 		doErrorBackoff(ct.Type)
@@ -195,10 +407,57 @@ func taskWorker(task QueueTask, ct config.Task) {
 	payload, _ := task.GetJSONString()
 	output.Debug("Executing task type", ct.Type, "- Payload:", payload)
 	txn := stats.StartedTask(ct.Type)
+	txn.SetTaskID(task.ID)
 
-	err := task.Execute(ct.Script)
+	started := time.Now()
+	if task.EnqueuedAt > 0 {
+		txn.SetWaitTime(task.GetWaitTime(started))
+	}
+	setTaskStatus(task, ct, statusRunning, map[string]interface{}{
+		"started_at": started.Unix(),
+		"host":       instanceHost,
+		"instance":   instanceID,
+	})
+
+	progress := newTaskProgress(task, ct, txn, started)
+	cancel := watchCancellation(task)
+	out, err := task.execute(ct, executeOptions{progress: progress.handle, cancel: cancel})
+	unwatchCancellation(cancel)
+	progress.finish()
+
+	// interrupted tasks did not fail, they are just handed back to their list
+	if err == errorTaskInterrupted {
+		txn.End()
+		output.Debug("Interrupted task type", ct.Type, "- Payload:", payload)
+		setTaskFinished(task, ct, statusQueued, started, getExitCode(err))
+		requeueTask(pt)
+		return
+	}
 
-	if err != nil {
+	// cancelled tasks did not fail either, they are not executed again
+	if err == errorTaskCancelled {
+		txn.End()
+		output.Debug("Cancelled running task type", ct.Type, "- Payload:", payload)
+		task.ErrorMessage = err.Error()
+		setTaskFinished(task, ct, statusCancelled, started, getExitCode(err))
+		finishCancelledTask(pt, task, ct, getExitCode(err))
+		return
+	}
+
+	// invalid follow-up tasks fail the task, as they could never be enqueued
+	var followUps []interface{}
+	if err == nil {
+		followUps, err = parseFollowUps(out.FollowUps, conf.Tasks, time.Now().Unix())
+	}
+
+	// scripts may request to retry or discard the task by their exit-code
+	exitCode := getExitCode(err)
+	// retries requested by the exit-code are limited, afterwards the task is handled as failed
+	retry := err != nil && ct.IsRetryExitCode(exitCode) && task.Attempts < ct.MaxRetries
+	discard := err != nil && ct.IsDiscardExitCode(exitCode)
+	failed := err != nil && !retry && !discard
+
+	if failed {
 		txn.NoticeError(err)
 	}
 	txn.End()
@@ -206,20 +465,57 @@ func taskWorker(task QueueTask, ct config.Task) {
 	if ct.BackoffEnabled {
 		if err == nil {
 			resetErrorBackoff(ct.Type)
-		} else {
+		} else if failed {
 			setErrorBackoff(ct.Type)
 		}
 	}
 
 	if err != nil {
 		task.ErrorMessage = fmt.Sprintf("%s", err)
+		task.Attempts++
+	}
+
+	switch {
+	case err == nil:
+		setTaskFinished(task, ct, statusSucceeded, started, exitCode)
+		storeTaskResult(task, ct, statusSucceeded, out, exitCode)
+		finishWorkflowStep(task, statusSucceeded)
+		releaseUniqueTask(task, ct)
+		if err = finishTaskWithFollowUps(pt, followUps); err != nil {
+			output.NotifyError("finishTaskWithFollowUps():", err, "\nPayload:\n", payload)
+		}
+
+	case discard:
+		output.Debug("Discarding task type", ct.Type, "with exit-code", exitCode)
+		setTaskFinished(task, ct, statusDiscarded, started, exitCode)
+		storeTaskResult(task, ct, statusDiscarded, out, exitCode)
+		finishWorkflowStep(task, statusDiscarded)
+		releaseUniqueTask(task, ct)
+		finishTask(pt)
+
+	case retry:
+		output.Debug("Retrying task type", ct.Type, "with exit-code", exitCode, "after attempt", task.Attempts)
+		setTaskFinished(task, ct, statusQueued, started, exitCode)
+		retryTask(pt, task, ct, ct.RetryExitDelay(task.Attempts))
+
+	case task.Attempts < ct.MaxAttempts:
+		output.Debug("Retrying task type", ct.Type, "after attempt", task.Attempts, "- Error:", err)
+		setTaskFinished(task, ct, statusQueued, started, exitCode)
+		retryTask(pt, task, ct, ct.RetryDelay(task.Attempts))
+
+	default:
 		failedChan <- failedTask{
 			configTask: ct,
 			queueTask:  task,
 		}
 
-		msg := fmt.Sprintf("Failed executing task for type \"%s\"\nPayload:\n%s\n\n%s", ct.Type, payload, err)
+		msg := fmt.Sprintf("Failed executing task for type \"%s\" (attempt %d of %d)\nPayload:\n%s\n\n%s", ct.Type, task.Attempts, ct.MaxAttempts, payload, err)
 		output.NotifyError(msg)
+		setTaskFinished(task, ct, statusFailed, started, exitCode)
+		storeTaskResult(task, ct, statusFailed, out, exitCode)
+		finishWorkflowStep(task, statusFailed)
+		releaseUniqueTask(task, ct)
+		finishTask(pt)
 	}
 
 	output.Debug("Finished task type", ct.Type, "- Payload:", payload)
@@ -228,35 +524,66 @@ func taskWorker(task QueueTask, ct config.Task) {
 func failedTaskWorker() {
 	defer waitGroupFailed.Done()
 
-	for ft := range failedChan {
-		ct := ft.configTask
-		qt := ft.queueTask
+	ticker := time.NewTicker(failedTasksTrimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ft, ok := <-failedChan:
+			if !ok {
+				return
+			}
+			storeFailedTask(ft)
 
-		if ct.FailedTasksTTL == 0 {
-			continue
+		case <-ticker.C:
+			for _, ct := range conf.Tasks {
+				trimFailedTasks(ct)
+				trimExpiredTasks(ct)
+			}
 		}
+	}
+}
 
-		queueKey := conf.RedisQueueKey + ":" + ct.Type + ":failed"
+func failedKey(taskType string) string {
+	return conf.RedisQueueKey + ":" + taskType + ":failed"
+}
 
-		jsonString, err := qt.GetJSONString()
-		if err != nil {
-			output.NotifyError("failedTaskWorker(), qt.GetJSONString():", err)
-			continue
-		}
+// storeFailedTask adds a failed task to the failed-list of its type
+// and removes the entries that exceeded their time-to-live.
+func storeFailedTask(ft failedTask) {
+	ct := ft.configTask
+	qt := ft.queueTask
 
-		// add to list
-		reply := redisPoolCmd(3, "RPUSH", queueKey, jsonString)
-		if reply.Err != nil {
-			output.NotifyError("failedTaskWorker(), RPUSH:", reply.Err, "\nPayload:\n", jsonString)
-			continue
-		}
+	if ct.FailedTasksTTL == 0 {
+		return
+	}
 
-		// set expire
-		reply = redisPoolCmd(3, "EXPIRE", queueKey, ct.FailedTasksTTL)
-		if reply.Err != nil {
-			output.NotifyError("failedTaskWorker(), EXPIRE:", reply.Err, "\nPayload:\n", jsonString)
-			continue
-		}
+	now := time.Now().Unix()
+	qt.FailedAt = now
+
+	jsonString, err := qt.GetJSONString()
+	if err != nil {
+		output.NotifyError("failedTaskWorker(), qt.GetJSONString():", err)
+		return
+	}
+
+	reply := redisPoolLua(3, failedScript, 1, failedKey(ct.Type), jsonString, now-int64(ct.FailedTasksTTL), ct.FailedTasksMax, ct.FailedTasksTTL, now, "failed_at")
+	if reply.Err != nil {
+		output.NotifyError("failedTaskWorker(), failedScript:", reply.Err, "\nPayload:\n", jsonString)
+	}
+}
+
+// trimFailedTasks removes the entries that exceeded their time-to-live from the
+// failed-list of the given type, even when no new tasks failed.
+func trimFailedTasks(ct config.Task) {
+	if ct.FailedTasksTTL == 0 {
+		return
+	}
+
+	now := time.Now().Unix()
+	reply := redisPoolLua(1, failedScript, 1, failedKey(ct.Type), "", now-int64(ct.FailedTasksTTL), ct.FailedTasksMax, ct.FailedTasksTTL, now, "failed_at")
+	if reply.Err != nil {
+		output.NotifyError("failedTaskWorker(), failedScript:", reply.Err)
 	}
 }
 
@@ -265,6 +592,18 @@ func redisDialFunction(network, addr string) (*redis.Client, error) {
 }
 
 func redisPoolCmd(retries int, cmd string, args ...interface{}) (resp *redis.Resp) {
+	return redisPoolRetry(retries, func() *redis.Resp {
+		return redisPool.Cmd(cmd, args...)
+	}, "\nCommand:\n"+cmd, fmt.Sprint(args...))
+}
+
+func redisPoolLua(retries int, script string, keys int, args ...interface{}) (resp *redis.Resp) {
+	return redisPoolRetry(retries, func() *redis.Resp {
+		return util.LuaEval(redisPool, script, keys, args...)
+	}, "\nScript:\n"+script, fmt.Sprint(args...))
+}
+
+func redisPoolRetry(retries int, fn func() *redis.Resp, debug ...interface{}) (resp *redis.Resp) {
 	cmdBackoff := backoff.Backoff{
 		Min:    time.Duration(250) * time.Millisecond,
 		Max:    time.Duration(2000) * time.Millisecond,
@@ -274,12 +613,12 @@ func redisPoolCmd(retries int, cmd string, args ...interface{}) (resp *redis.Res
 
 	i := 0
 	for i < retries {
-		resp = redisPool.Cmd(cmd, args...)
+		resp = fn()
 		if resp.Err == nil {
 			break
 		}
 
-		output.Debug("redisPool.Cmd() Error:", resp.Err, "\nCommand:\n"+cmd, fmt.Sprint(args...))
+		output.Debug(append([]interface{}{"redisPool.Cmd() Error:", resp.Err}, debug...)...)
 		i++
 
 		if i < retries {
@@ -309,6 +648,8 @@ func setShutdown() {
 
 var (
 	workerCount     map[string]int
+	workerCost      int    // sum of the costs of all running tasks, limited by max_workers
+	workerReserved  string // type for which the budget that becomes free is held back, if any
 	workerCountLock sync.Mutex
 )
 
@@ -319,14 +660,73 @@ func createWorkerCount(taskType string) {
 	workerCount[taskType] = 0
 }
 
+// isWorkerAvailable returns true, if a task of the given type can be started without exceeding
+// its number of workers and, if configured, the max_workers budget shared by all types.
 func isWorkerAvailable(taskType string) bool {
 	workerCountLock.Lock()
 	defer workerCountLock.Unlock()
 
+	ct := conf.Tasks[taskType]
 	currentCount := workerCount[taskType]
-	maxCount := conf.Tasks[taskType].Workers
+	maxCount := ct.Workers
+	if currentCount >= maxCount {
+		return false
+	}
 
-	return currentCount < maxCount
+	if conf.MaxWorkers <= 0 {
+		return true
+	}
+
+	// the budget reserved by another type may not be used, so its tasks are not starved by cheaper types
+	budget := conf.MaxWorkers - workerCost
+	if workerReserved == taskType {
+		if ct.Cost > budget {
+			return false
+		}
+		workerReserved = ""
+		return true
+	}
+	if workerReserved != "" {
+		budget -= conf.Tasks[workerReserved].Cost
+	}
+
+	return ct.Cost <= budget
+}
+
+// exceedsWorkerBudget returns true, if a task of the given type could be started, if only the
+// max_workers budget allowed it.
+func exceedsWorkerBudget(taskType string) bool {
+	workerCountLock.Lock()
+	defer workerCountLock.Unlock()
+
+	ct := conf.Tasks[taskType]
+	return conf.MaxWorkers > 0 && workerCount[taskType] < ct.Workers && workerCost+ct.Cost > conf.MaxWorkers
+}
+
+// reserveWorkerBudget holds back the budget that becomes free for the given type, until
+// its cost fits into the budget. Only one type reserves the budget at a time.
+func reserveWorkerBudget(taskType string) {
+	workerCountLock.Lock()
+	defer workerCountLock.Unlock()
+
+	if workerReserved == "" {
+		workerReserved = taskType
+	}
+}
+
+// hasQueuedTasks returns true, if any list of the passed type contains tasks.
+func hasQueuedTasks(ct config.Task) bool {
+	for _, queueKey := range taskQueueKeys(ct) {
+		n, err := redisPoolCmd(1, "LLEN", queueKey).Int()
+		if err != nil {
+			output.NotifyError("hasQueuedTasks(), LLEN:", err)
+			return false
+		}
+		if n > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 func claimWorker(taskType string) {
@@ -336,6 +736,7 @@ func claimWorker(taskType string) {
 	defer workerCountLock.Unlock()
 
 	workerCount[taskType]++
+	workerCost += conf.Tasks[taskType].Cost
 }
 
 func returnWorker(taskType string) {
@@ -343,7 +744,11 @@ func returnWorker(taskType string) {
 	defer workerCountLock.Unlock()
 
 	workerCount[taskType]--
+	workerCost -= conf.Tasks[taskType].Cost
 	waitGroup.Done()
+
+	// a worker became available, so there might be tasks left to handle
+	wakeup()
 }
 
 type workerBackoff struct {
@@ -0,0 +1,147 @@
+package taskqueue
+
+import (
+	"fmt"
+	"github.com/nevsnode/gordon/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerBudget(t *testing.T) {
+	conf = config.Config{
+		MaxWorkers: 5,
+		Tasks: map[string]config.Task{
+			"light": {Type: "light", Workers: 4, Cost: 1},
+			"heavy": {Type: "heavy", Workers: 2, Cost: 3},
+		},
+	}
+	for taskType := range conf.Tasks {
+		createWorkerCount(taskType)
+	}
+	defer func() {
+		conf = config.Config{}
+	}()
+
+	claimWorker("heavy")
+	if isWorkerAvailable("heavy") {
+		t.Log("isWorkerAvailable() should return false, when the cost exceeds the remaining budget")
+		t.Fail()
+	}
+
+	claimWorker("light")
+	claimWorker("light")
+	if isWorkerAvailable("light") {
+		t.Log("isWorkerAvailable() should return false, when the budget is used up")
+		t.Fail()
+	}
+
+	returnWorker("heavy")
+	if !isWorkerAvailable("heavy") || !isWorkerAvailable("light") {
+		t.Log("isWorkerAvailable() should return true, when the budget was freed again")
+		t.Fail()
+	}
+
+	claimWorker("light")
+	claimWorker("light")
+	if isWorkerAvailable("light") {
+		t.Log("isWorkerAvailable() should still respect the workers of the type")
+		t.Fail()
+	}
+
+	for i := 0; i < 4; i++ {
+		returnWorker("light")
+	}
+	if workerCost != 0 {
+		t.Log("returnWorker() should give back the cost of the task")
+		t.Log("workerCost:", workerCost)
+		t.Fail()
+	}
+}
+
+func TestWorkerBudgetReservation(t *testing.T) {
+	conf = config.Config{
+		MaxWorkers: 5,
+		Tasks: map[string]config.Task{
+			"light": {Type: "light", Workers: 5, Cost: 1},
+			"heavy": {Type: "heavy", Workers: 1, Cost: 3},
+		},
+	}
+	for taskType := range conf.Tasks {
+		createWorkerCount(taskType)
+	}
+	defer func() {
+		conf = config.Config{}
+		workerReserved = ""
+	}()
+
+	for i := 0; i < 5; i++ {
+		claimWorker("light")
+	}
+
+	// every round one light task finishes, and both types try to start as many tasks as possible
+	heavyStarted := false
+	for round := 0; round < 5 && !heavyStarted; round++ {
+		returnWorker("light")
+
+		for _, taskType := range []string{"light", "heavy"} {
+			for isWorkerAvailable(taskType) {
+				claimWorker(taskType)
+				heavyStarted = heavyStarted || taskType == "heavy"
+			}
+			if exceedsWorkerBudget(taskType) {
+				reserveWorkerBudget(taskType)
+			}
+		}
+	}
+
+	if !heavyStarted {
+		t.Log("isWorkerAvailable() should hold back the freed budget, until the heavy type fits")
+		t.FailNow()
+	}
+	if workerReserved != "" {
+		t.Log("isWorkerAvailable() should release the reservation, when the heavy type fits")
+		t.Fail()
+	}
+
+	for _, taskType := range []string{"light", "light", "heavy"} {
+		returnWorker(taskType)
+	}
+	if workerCost != 0 {
+		t.Log("returnWorker() should give back the cost of the task")
+		t.Log("workerCost:", workerCost)
+		t.Fail()
+	}
+}
+
+func TestTrimFailedTasks(t *testing.T) {
+	defer testRedis(t)()
+
+	ct := config.Task{Type: "type", FailedTasksTTL: 60}
+	now := time.Now().Unix()
+	redisPool.Cmd("RPUSH", failedKey(ct.Type),
+		`{"args":["legacy"]}`,
+		fmt.Sprintf(`{"args":["expired"],"failed_at":%d}`, now-120),
+		"invalid",
+		`{}`,
+		fmt.Sprintf(`{"args":["expired"],"failed_at":%d}`, now-120),
+		fmt.Sprintf(`{"args":["current"],"failed_at":%d}`, now))
+
+	trimFailedTasks(ct)
+
+	values, _ := redisPool.Cmd("LRANGE", failedKey(ct.Type), 0, -1).List()
+	if len(values) != 3 || !strings.Contains(values[0], `"current"`) {
+		t.Log("trimFailedTasks() should remove expired entries behind entries without failed_at")
+		t.Log("values: ", values)
+		t.FailNow()
+	}
+
+	for _, value := range values[1:] {
+		task, err := NewQueueTask(value)
+		if err != nil || task.FailedAt < now {
+			t.Log("trimFailedTasks() should set failed_at of entries without it to the current time")
+			t.Log("value: ", value, "err: ", err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for unique tasks, of which duplicates are dropped while
+// one of them is queued or running.
+package taskqueue
+
+import (
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+)
+
+// uniqueLockScript acquires the lock (KEYS[2]) of a unique task, or drops the task from the
+// processing-list (KEYS[1]) when another task holds it. ARGV[1] is the raw value in the
+// processing-list, ARGV[2] the value with the token of the lock, which replaces the raw value,
+// ARGV[3] the token and ARGV[4] the ttl of the lock.
+// The lock is acquired when it does not exist, was set by a client when enqueueing the task,
+// or is already held by the task itself. It returns 1 when the lock was acquired.
+const uniqueLockScript = `
+local current = redis.call('GET', KEYS[2])
+if current and current ~= 'queued' and current ~= ARGV[3] then
+	redis.call('LREM', KEYS[1], 1, ARGV[1])
+	return 0
+end
+
+redis.call('SET', KEYS[2], ARGV[3], 'EX', ARGV[4])
+if ARGV[1] ~= ARGV[2] and redis.call('LREM', KEYS[1], 1, ARGV[1]) > 0 then
+	redis.call('RPUSH', KEYS[1], ARGV[2])
+end
+return 1
+`
+
+// uniqueRefreshScript sets the ttl (ARGV[2]) of a lock (KEYS[1]), if it is held by the token in ARGV[1].
+const uniqueRefreshScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`
+
+// uniqueReleaseScript deletes a lock (KEYS[1]), if it is held by the token in ARGV[1].
+const uniqueReleaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+end
+return 1
+`
+
+func uniqueKey(ct config.Task, key string) string {
+	return conf.RedisQueueKey + ":" + ct.Type + ":unique:" + key
+}
+
+// lockUniqueTask acquires the lock on the unique key of a fetched task. It returns false,
+// when the task is a duplicate and was dropped. As the token of the lock is stored in the task,
+// its value in the processing-list is updated, so the lock is kept when the task is re-queued.
+func lockUniqueTask(pt *processingTask, task *QueueTask, ct config.Task) (bool, error) {
+	key := task.GetUniqueKey(ct)
+	if key == "" {
+		return true, nil
+	}
+
+	if task.UniqueLock == "" {
+		task.UniqueLock = createToken()
+	}
+
+	value, err := task.GetJSONString()
+	if err != nil {
+		return false, err
+	}
+
+	locked, err := redisPoolLua(3, uniqueLockScript, 2, processingKey(pt.queueKey, instanceID), uniqueKey(ct, key), pt.value, value, task.UniqueLock, ct.UniqueTTL).Int()
+	if err != nil {
+		return false, err
+	}
+	if locked == 0 {
+		return false, nil
+	}
+
+	pt.value = value
+	return true, nil
+}
+
+// refreshUniqueTask extends the lock of a unique task by the passed amount of seconds,
+// so it is kept while the task waits for being retried.
+func refreshUniqueTask(task QueueTask, ct config.Task, delay int) {
+	key := task.GetUniqueKey(ct)
+	if key == "" || task.UniqueLock == "" {
+		return
+	}
+
+	reply := redisPoolLua(3, uniqueRefreshScript, 1, uniqueKey(ct, key), task.UniqueLock, ct.UniqueTTL+delay)
+	if reply.Err != nil {
+		output.NotifyError("refreshUniqueTask(), uniqueRefreshScript:", reply.Err)
+	}
+}
+
+// releaseUniqueTask releases the lock of a unique task, after its execution finished.
+func releaseUniqueTask(task QueueTask, ct config.Task) {
+	key := task.GetUniqueKey(ct)
+	if key == "" || task.UniqueLock == "" {
+		return
+	}
+
+	reply := redisPoolLua(3, uniqueReleaseScript, 1, uniqueKey(ct, key), task.UniqueLock)
+	if reply.Err != nil {
+		output.NotifyError("releaseUniqueTask(), uniqueReleaseScript:", reply.Err)
+	}
+}
@@ -0,0 +1,333 @@
+// Package taskqueue provides the functionality for receiving, handling and executing tasks.
+// In this file are the routines for workflows, which consist of several tasks that are
+// enqueued when the tasks they depend on succeeded.
+package taskqueue
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/nevsnode/gordon/config"
+	"github.com/nevsnode/gordon/output"
+	"sort"
+	"time"
+)
+
+// Available values for the status of workflows and their steps.
+const (
+	workflowRunning = "running" // the workflow has steps that did not finish yet
+	workflowFailed  = "failed"  // a step of the workflow failed permanently
+	workflowPending = "pending" // the step waits for the steps it depends on
+	workflowQueued  = "queued"  // the step was enqueued as task
+)
+
+// workflowStartScript creates the state of a workflow in a hash (KEYS[1]), unless it exists already.
+// ARGV[1] is the ttl of the hash and ARGV[2] the number of fields, which follow as pairs of field and
+// value. The remaining arguments are pairs of a list and a task, which are pushed to the list.
+const workflowStartScript = `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+
+local fields = tonumber(ARGV[2])
+redis.call('HSET', KEYS[1], unpack(ARGV, 3, 2 + fields * 2))
+redis.call('EXPIRE', KEYS[1], ARGV[1])
+
+for i = 3 + fields * 2, #ARGV, 2 do
+	redis.call('RPUSH', ARGV[i], ARGV[i + 1])
+end
+return 1
+`
+
+// workflowStepScript updates the state of a workflow (KEYS[1]), after the step ARGV[1] finished
+// with the status ARGV[2] at the timestamp ARGV[3]. When it succeeded, the steps depending on it
+// are enqueued, as soon as all of their dependencies succeeded, with ARGV[3] as their enqueued_at.
+// When it failed, the whole workflow is marked failed and no further steps are enqueued.
+const workflowStepScript = `
+local state, step = KEYS[1], ARGV[1]
+if redis.call('HGET', state, 'step:' .. step) ~= 'queued' then
+	return 0
+end
+
+redis.call('HSET', state, 'step:' .. step, ARGV[2])
+if redis.call('HGET', state, 'status') ~= 'running' then
+	return 1
+end
+
+if ARGV[2] ~= 'succeeded' then
+	redis.call('HSET', state, 'status', 'failed', 'failed_step', step, 'finished_at', ARGV[3])
+	return 1
+end
+
+for _, next in ipairs(cjson.decode(redis.call('HGET', state, 'dependents:' .. step))) do
+	if redis.call('HINCRBY', state, 'pending:' .. next, -1) == 0 then
+		redis.call('HSET', state, 'step:' .. next, 'queued')
+		local task = redis.call('HGET', state, 'task:' .. next)
+		task = string.sub(task, 1, -2) .. ',"enqueued_at":' .. ARGV[3] .. '}'
+		redis.call('RPUSH', redis.call('HGET', state, 'list:' .. next), task)
+	end
+end
+
+if redis.call('HINCRBY', state, 'remaining', -1) == 0 then
+	redis.call('HSET', state, 'status', 'succeeded', 'finished_at', ARGV[3])
+end
+return 1
+`
+
+// A workflow is the definition of a workflow, as it is pushed to the workflows-list.
+type workflow struct {
+	ID    string                   `json:"id"`    // identifier of the workflow
+	Steps map[string]*workflowStep `json:"steps"` // steps of the workflow by their name
+}
+
+// A workflowStep is a task of a workflow. Besides the type of the task and the steps
+// it depends on, it contains the properties of the task itself.
+type workflowStep struct {
+	QueueTask
+	Type  string   `json:"type"`  // type of the task
+	After []string `json:"after"` // names of the steps that have to succeed before this step
+}
+
+func workflowsKey() string {
+	return conf.RedisQueueKey + ":workflows"
+}
+
+func workflowKey(id string) string {
+	return conf.RedisQueueKey + ":workflow:" + id
+}
+
+// newWorkflow parses and validates a workflow. The types of all steps have to be configured,
+// and their dependencies must exist and must not contain cycles.
+func newWorkflow(value string, tasks map[string]config.Task) (*workflow, error) {
+	var w workflow
+	if err := json.Unmarshal([]byte(value), &w); err != nil {
+		return nil, err
+	}
+
+	if w.ID == "" {
+		w.ID = createToken()
+	}
+	if len(w.Steps) == 0 {
+		return nil, fmt.Errorf("Workflow %s has no steps", w.ID)
+	}
+
+	for name, step := range w.Steps {
+		if step == nil {
+			return nil, fmt.Errorf("Workflow %s: step %q is empty", w.ID, name)
+		}
+		if _, ok := tasks[step.Type]; !ok {
+			return nil, fmt.Errorf("Workflow %s: step %q has the unknown type %q", w.ID, name, step.Type)
+		}
+		seen := make(map[string]bool)
+		for _, after := range step.After {
+			if _, ok := w.Steps[after]; !ok {
+				return nil, fmt.Errorf("Workflow %s: step %q depends on the unknown step %q", w.ID, name, after)
+			}
+			if seen[after] {
+				return nil, fmt.Errorf("Workflow %s: step %q depends on the step %q twice", w.ID, name, after)
+			}
+			seen[after] = true
+		}
+	}
+
+	// steps can be ordered, when there are no cycles
+	done := make(map[string]bool)
+	for len(done) < len(w.Steps) {
+		progress := false
+		for name, step := range w.Steps {
+			if !done[name] && w.isReady(step, done) {
+				done[name] = true
+				progress = true
+			}
+		}
+
+		if !progress {
+			return nil, fmt.Errorf("Workflow %s contains a cycle", w.ID)
+		}
+	}
+
+	return &w, nil
+}
+
+// isReady returns true, if all dependencies of the step are contained in done.
+func (w *workflow) isReady(step *workflowStep, done map[string]bool) bool {
+	for _, after := range step.After {
+		if !done[after] {
+			return false
+		}
+	}
+	return true
+}
+
+// names returns the names of the steps in a stable order.
+func (w *workflow) names() []string {
+	names := make([]string, 0, len(w.Steps))
+	for name := range w.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dependents returns the names of the steps depending on the passed step.
+func (w *workflow) dependents(name string) []string {
+	dependents := make([]string, 0)
+	for _, other := range w.names() {
+		for _, after := range w.Steps[other].After {
+			if after == name {
+				dependents = append(dependents, other)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// state returns the fields of the hash storing the state of the workflow, and the tasks of
+// the steps without dependencies as pairs of the list and the task. The other steps get their
+// enqueued_at added by the workflowStepScript, when they are enqueued.
+func (w *workflow) state(tasks map[string]config.Task, now int64) (fields []interface{}, roots []interface{}, err error) {
+	fields = []interface{}{
+		"status", workflowRunning,
+		"created_at", now,
+		"remaining", len(w.Steps),
+	}
+
+	for _, name := range w.names() {
+		step := w.Steps[name]
+		ct := tasks[step.Type]
+
+		task := step.QueueTask
+		task.Workflow = w.ID
+		task.Step = name
+		if task.ID == "" {
+			task.ID = w.ID + "-" + name
+		}
+		if len(step.After) > 0 {
+			task.EnqueuedAt = 0
+		} else if task.EnqueuedAt == 0 {
+			task.EnqueuedAt = now
+		}
+
+		value, err := task.GetJSONString()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dependents, err := json.Marshal(w.dependents(name))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		priority := ""
+		if ct.HasPriority(task.Priority) {
+			priority = task.Priority
+		}
+		list := taskQueueKey(ct, priority)
+
+		status := workflowPending
+		if len(step.After) == 0 {
+			status = workflowQueued
+			roots = append(roots, list, value)
+		}
+
+		fields = append(fields,
+			"step:"+name, status,
+			"pending:"+name, len(step.After),
+			"dependents:"+name, string(dependents),
+			"list:"+name, list,
+			"task:"+name, value,
+		)
+	}
+
+	return
+}
+
+// workflowWorker fetches new workflows from the workflows-list and starts them.
+func workflowWorker() {
+	defer waitGroup.Done()
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if isShuttingDown() {
+			break
+		}
+
+		// start all workflows that are available
+		for !isShuttingDown() {
+			if !startWorkflow() {
+				break
+			}
+		}
+	}
+
+	output.Debug("Finished workflow-worker")
+}
+
+// startWorkflow fetches the next workflow and creates its state. It returns false,
+// when there are no more workflows or an error occurred.
+func startWorkflow() bool {
+	pt := processingTask{queueKey: workflowsKey()}
+
+	reply := redisPoolCmd(3, "LMOVE", pt.queueKey, processingKey(pt.queueKey, instanceID), "LEFT", "RIGHT")
+	if reply.IsType(redis.Nil) {
+		return false
+	}
+
+	var err error
+	pt.value, err = reply.Str()
+	if err != nil {
+		output.NotifyError("startWorkflow(), LMOVE:", err)
+		return false
+	}
+
+	// invalid workflows are dropped, as they would never become valid
+	w, err := newWorkflow(pt.value, conf.Tasks)
+	if err != nil {
+		output.NotifyError("startWorkflow(), newWorkflow():", err, "\nPayload:\n", pt.value)
+		finishTask(pt)
+		return true
+	}
+
+	fields, roots, err := w.state(conf.Tasks, time.Now().Unix())
+	if err != nil {
+		output.NotifyError("startWorkflow(), workflow.state():", err, "\nPayload:\n", pt.value)
+		finishTask(pt)
+		return true
+	}
+
+	started, err := redisPoolLua(3, workflowStartScript, 1, workflowKey(w.ID), conf.WorkflowTTL, len(fields)/2, fields, roots).Int()
+	if err != nil {
+		output.NotifyError("startWorkflow(), workflowStartScript:", err)
+		requeueTask(pt)
+		return false
+	}
+
+	if started == 0 {
+		output.NotifyError("startWorkflow(): Workflow", w.ID, "exists already\nPayload:\n", pt.value)
+	} else {
+		output.Debug("Started workflow", w.ID, "with", len(w.Steps), "steps")
+	}
+
+	finishTask(pt)
+	return true
+}
+
+// finishWorkflowStep updates the workflow of a task after its final execution,
+// which enqueues the following steps or marks the workflow as failed.
+func finishWorkflowStep(task QueueTask, status string) {
+	if task.Workflow == "" || task.Step == "" {
+		return
+	}
+
+	if status != statusSucceeded {
+		status = workflowFailed
+	}
+
+	reply := redisPoolLua(3, workflowStepScript, 1, workflowKey(task.Workflow), task.Step, status, time.Now().Unix())
+	if reply.Err != nil {
+		output.NotifyError("finishWorkflowStep(), workflowStepScript:", reply.Err, "\nWorkflow:", task.Workflow)
+	}
+}
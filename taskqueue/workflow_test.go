@@ -0,0 +1,132 @@
+package taskqueue
+
+import (
+	"github.com/nevsnode/gordon/config"
+	"reflect"
+	"testing"
+)
+
+var testWorkflowTasks = map[string]config.Task{
+	"import": {Type: "import", Priorities: []string{"normal"}, DefaultPriority: "normal"},
+	"resize": {Type: "resize", Priorities: []string{"high", "normal"}, DefaultPriority: "normal"},
+}
+
+func TestNewWorkflow(t *testing.T) {
+	invalid := []string{
+		`{"id":"wf","steps":{}}`,
+		`{"id":"wf","steps":{"a":{"type":"unknown"}}}`,
+		`{"id":"wf","steps":{"a":{"type":"import","after":["b"]}}}`,
+		`{"id":"wf","steps":{"a":{"type":"import"},"b":{"type":"import","after":["a","a"]}}}`,
+		`{"id":"wf","steps":{"a":{"type":"import","after":["b"]},"b":{"type":"import","after":["a"]}}}`,
+		`{"id":"wf","steps":{"a":{"type":"import","after":["a"]}}}`,
+		`{"id":"wf","steps":{"a":null}}`,
+		`no json`,
+	}
+	for _, value := range invalid {
+		if _, err := newWorkflow(value, testWorkflowTasks); err == nil {
+			t.Log("newWorkflow() should return an error for invalid workflows")
+			t.Log("value:", value)
+			t.Fail()
+		}
+	}
+
+	w, err := newWorkflow(`{"steps":{"a":{"type":"import","args":["1"]}}}`, testWorkflowTasks)
+	if err != nil || w.ID == "" {
+		t.Log("newWorkflow() should assign an id to workflows without one")
+		t.Log("err:", err)
+		t.Fail()
+	}
+}
+
+func TestWorkflowState(t *testing.T) {
+	conf.RedisQueueKey = "test"
+	w, err := newWorkflow(`{"id":"wf","steps":{
+		"a":{"type":"import","args":["1"]},
+		"b":{"type":"resize","after":["a"],"priority":"high"},
+		"c":{"type":"resize","after":["a"]},
+		"d":{"type":"import","after":["b","c"]}
+	}}`, testWorkflowTasks)
+	if err != nil {
+		t.Log("newWorkflow() should not return an error")
+		t.Log("err:", err)
+		t.FailNow()
+	}
+
+	if !reflect.DeepEqual(w.dependents("a"), []string{"b", "c"}) || len(w.dependents("d")) != 0 {
+		t.Log("workflow.dependents() should return the steps depending on a step")
+		t.Fail()
+	}
+
+	fields, roots, err := w.state(testWorkflowTasks, 1500000000)
+	if err != nil {
+		t.Log("workflow.state() should not return an error")
+		t.Log("err:", err)
+		t.FailNow()
+	}
+
+	expectedRoots := []interface{}{"test:import", `{"id":"wf-a","args":["1"],"env":{},"enqueued_at":1500000000,"workflow":"wf","step":"a"}`}
+	if !reflect.DeepEqual(roots, expectedRoots) {
+		t.Log("workflow.state() should return the steps without dependencies as roots")
+		t.Log("expected:", expectedRoots)
+		t.Log("returned:", roots)
+		t.Fail()
+	}
+
+	state := make(map[interface{}]interface{})
+	for i := 0; i < len(fields); i += 2 {
+		state[fields[i]] = fields[i+1]
+	}
+
+	expected := map[string]interface{}{
+		"status":       workflowRunning,
+		"remaining":    4,
+		"step:a":       workflowQueued,
+		"step:d":       workflowPending,
+		"pending:d":    2,
+		"dependents:a": `["b","c"]`,
+		"dependents:d": `[]`,
+		"list:b":       "test:resize:high",
+		"list:c":       "test:resize",
+		"task:d":       `{"id":"wf-d","args":[],"env":{},"workflow":"wf","step":"d"}`,
+	}
+	for field, value := range expected {
+		if state[field] != value {
+			t.Log("workflow.state() should return the expected fields")
+			t.Log("field:", field, "expected:", value, "returned:", state[field])
+			t.Fail()
+		}
+	}
+}
+
+func TestParseFollowUps(t *testing.T) {
+	conf.RedisQueueKey = "test"
+
+	pairs, err := parseFollowUps([]string{
+		`{"type":"import","args":["1"]}`,
+		`{"type":"resize","args":["2"],"priority":"high","enqueued_at":1400000000}`,
+	}, testWorkflowTasks, 1500000000)
+	if err != nil {
+		t.Log("parseFollowUps() should not return an error")
+		t.Log("err:", err)
+		t.FailNow()
+	}
+
+	expected := []interface{}{
+		"test:import", `{"args":["1"],"env":{},"enqueued_at":1500000000}`,
+		"test:resize:high", `{"args":["2"],"env":{},"enqueued_at":1400000000,"priority":"high"}`,
+	}
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Log("parseFollowUps() should return the lists and tasks")
+		t.Log("expected:", expected)
+		t.Log("returned:", pairs)
+		t.Fail()
+	}
+
+	for _, record := range []string{`{"type":"unknown"}`, `{"args":["1"]}`, `no json`} {
+		if _, err := parseFollowUps([]string{record}, testWorkflowTasks, 1500000000); err == nil {
+			t.Log("parseFollowUps() should return an error for invalid records")
+			t.Log("record:", record)
+			t.Fail()
+		}
+	}
+}
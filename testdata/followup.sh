@@ -0,0 +1,4 @@
+#!/bin/bash
+for record in "$@"; do
+	echo "$record" >&$GORDON_TASKS_FD
+done
@@ -0,0 +1,4 @@
+#!/bin/bash
+printf "$1"
+printf "$2" >&2
+exit $3
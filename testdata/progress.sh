@@ -0,0 +1,4 @@
+#!/bin/bash
+for line in "$@"; do
+	echo "$line" >&$GORDON_PROGRESS_FD
+done
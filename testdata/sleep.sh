@@ -0,0 +1,4 @@
+#!/bin/bash
+trap "" TERM
+sleep "$1" &
+wait